@@ -2,84 +2,62 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"log/slog"
 	"os"
-	"runtime"
-	"time"
 
 	"github.com/uamana/hserv/internal/chunklog"
+	"github.com/uamana/hserv/internal/config"
 	"github.com/uamana/hserv/internal/hserv"
 )
 
 func main() {
-	var (
-		addr         string
-		rootDir      string
-		sidName      string
-		uidName      string
-		chunkExt     string
-		chunkMIME    string
-		bufferSize   int
-		tlsCertPath  string
-		tlsKeyPath   string
-		dbConnString string
-		workerCount  int
-		batchSize    int
-		batchTimeout time.Duration
-		channelCap   int
-	)
-	flag.StringVar(&addr, "addr", ":6443", "address to listen on")
-	flag.StringVar(&rootDir, "root", ".", "root directory to serve")
-	flag.StringVar(&sidName, "sid", "sid", "name of the sid parameter")
-	flag.StringVar(&uidName, "uid", "uid", "name of the uid cookie")
-	flag.StringVar(&chunkExt, "ext", ".ts", "extension of the chunk files")
-	flag.StringVar(&chunkMIME, "mime", "video/mp2t", "MIME type of the chunk files")
-	flag.IntVar(&bufferSize, "bsize", 1024, "buffer size for the scanner")
-	flag.StringVar(&tlsCertPath, "cert", "", "path to the TLS certificate")
-	flag.StringVar(&tlsKeyPath, "key", "", "path to the TLS key")
-	flag.StringVar(&dbConnString, "db", "", "connection string for the database")
-	flag.IntVar(&workerCount, "workers", 0, "number of workers for the chunk log writer")
-	flag.IntVar(&batchSize, "batch", 1000, "batch size for the chunk log writer")
-	flag.DurationVar(&batchTimeout, "batchtimeout", 200*time.Millisecond, "batch timeout for the chunk log writer")
-	flag.IntVar(&channelCap, "channelcap", 0, "channel capacity for the chunk log writer")
-	flag.Parse()
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	if dbConnString != "" {
-		if workerCount <= 0 {
-			workerCount = runtime.NumCPU()
-		}
-		if batchSize <= 0 {
-			slog.Error("batch size must be greater than 0 when database connection string is provided")
+	if len(os.Args) > 1 && os.Args[1] == "privacy" {
+		if err := runPrivacy(os.Args[2:]); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(0)
+			}
+			if errors.Is(err, errPrivacyUsage) {
+				os.Exit(2)
+			}
+			slog.Error("privacy request failed", "error", err)
 			os.Exit(1)
 		}
-		if channelCap <= 0 {
-			channelCap = workerCount * batchSize * 2
-		}
+		return
 	}
 
-	hserv := &hserv.HServ{
-		Addr:        addr,
-		RootDir:     rootDir,
-		SidName:     sidName,
-		UidName:     uidName,
-		ChunkExt:    chunkExt,
-		ChunkMIME:   chunkMIME,
-		BufferSize:  bufferSize,
-		TLSCertPath: tlsCertPath,
-		TLSKeyPath:  tlsKeyPath,
+	loadConfig := func() (*config.Config, error) {
+		return config.Load(os.Args[1:])
 	}
+	cfg, err := loadConfig()
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(2)
+	}
+
+	level, _ := cfg.Level()
+	slog.SetLogLoggerLevel(level)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hserv := hserv.New(cfg)
+	hserv.LoadConfig = loadConfig
 
-	if dbConnString != "" {
+	if cfg.DBConnString != "" {
 		chunkWriter, err := chunklog.NewWriter(ctx, chunklog.Config{
-			ConnString:   dbConnString,
-			WorkerCount:  workerCount,
-			BatchSize:    batchSize,
-			BatchTimeout: batchTimeout,
-			ChannelCap:   channelCap,
+			ConnString:   cfg.DBConnString,
+			WorkerCount:  cfg.WorkerCount,
+			BatchSize:    cfg.BatchSize,
+			BatchTimeout: cfg.BatchTimeout.Duration,
+			ChannelCap:   cfg.ChannelCap,
+			GeoDB:        cfg.GeoDB,
+			ASNDB:        cfg.ASNDB,
+			GeoRefresh:   cfg.GeoRefresh.Duration,
 		})
 		if err != nil {
 			slog.Error("failed to create chunk log writer", "error", err)
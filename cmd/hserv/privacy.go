@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/uamana/hserv/internal/chunklog"
+	"github.com/uamana/hserv/internal/config"
+	"github.com/uamana/hserv/internal/hserv"
+)
+
+const privacyUsage = `usage: hserv privacy export -uid UID|-subject SUB [-format json|csv] [-o FILE] [flags]
+       hserv privacy erase -uid UID|-subject SUB [-anonymize] [flags]`
+
+var errPrivacyUsage = errors.New("missing or unknown privacy command")
+
+// runPrivacy handles the privacy subcommands for data subject requests.
+func runPrivacy(args []string) error {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "erase") {
+		fmt.Fprintln(os.Stderr, privacyUsage)
+		return errPrivacyUsage
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("hserv privacy "+action, flag.ContinueOnError)
+	var (
+		uidStr    = fs.String("uid", "", "uid of the data subject")
+		subject   = fs.String("subject", "", "token subject of the data subject, for authenticated listeners")
+		connStr   = fs.String("db", os.Getenv("HSERV_DB"), "connection string for the database")
+		cfgPath   = fs.String("config", "", "read the database connection string from this hserv config file")
+		operator  = fs.String("operator", os.Getenv("USER"), "who runs the request, for the audit log")
+		reason    = fs.String("reason", "", "ticket or reference of the request, for the audit log")
+		format    = fs.String("format", "json", "export format: json or csv")
+		output    = fs.String("o", "", "export file (default stdout)")
+		anonymize = fs.Bool("anonymize", false, "anonymize the rows instead of deleting them")
+	)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if (*uidStr == "") == (*subject == "") {
+		return errors.New("give either -uid or -subject")
+	}
+	if *subject != "" {
+		*uidStr = hserv.SubjectUID(*subject)
+	}
+	uid, err := uuid.Parse(*uidStr)
+	if err != nil {
+		return fmt.Errorf("invalid uid %q", *uidStr)
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("invalid format %q", *format)
+	}
+	var privacy *config.Privacy
+	if *cfgPath != "" {
+		cfg, err := config.Load([]string{"-config", *cfgPath})
+		if err != nil {
+			return err
+		}
+		*connStr = cfg.DBConnString
+		privacy = &cfg.Privacy
+	}
+	if *connStr == "" {
+		return errors.New("no database given, use -db, -config or HSERV_DB")
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, *connStr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	req := chunklog.PrivacyRequest{UID: uid, Operator: *operator, Reason: *reason}
+	if privacy != nil && privacy.PseudonymizeUID {
+		if req.StoredUIDs, err = storedUIDs(ctx, conn, privacy, uid); err != nil {
+			return err
+		}
+	}
+	if action == "erase" {
+		n, err := chunklog.EraseUID(ctx, conn, req, *anonymize)
+		if err != nil {
+			return err
+		}
+		slog.Info("privacy erase done", "uid", uid, "anonymize", *anonymize, "rows", n, "operator", *operator, "reason", *reason)
+		return nil
+	}
+
+	tables, err := chunklog.ExportUID(ctx, conn, req)
+	if err != nil {
+		return err
+	}
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "csv" {
+		err = writeExportCSV(w, tables)
+	} else {
+		err = writeExportJSON(w, tables)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("privacy export done", "uid", uid, "operator", *operator, "reason", *reason)
+	return nil
+}
+
+// storedUIDs returns the pseudonyms uid may be stored under, over the logged
+// time range, and the uid itself for rows logged before pseudonymization.
+func storedUIDs(ctx context.Context, conn *pgx.Conn, p *config.Privacy, uid uuid.UUID) ([]uuid.UUID, error) {
+	first, last, err := chunklog.LoggedTimeRange(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	uids := []uuid.UUID{uid}
+	if first.IsZero() {
+		return uids, nil
+	}
+	pseudonyms, err := hserv.Pseudonyms(p, uid.String(), first, last)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range pseudonyms {
+		uids = append(uids, uuid.MustParse(s))
+	}
+	return uids, nil
+}
+
+// writeExportJSON writes an object with an array of row objects per table.
+func writeExportJSON(w io.Writer, tables []chunklog.ExportTable) error {
+	out := make(map[string][]map[string]any, len(tables))
+	for _, t := range tables {
+		rows := make([]map[string]any, 0, len(t.Rows))
+		for _, row := range t.Rows {
+			m := make(map[string]any, len(row))
+			for i, v := range row {
+				m[t.Columns[i]] = exportValue(v)
+			}
+			rows = append(rows, m)
+		}
+		out[t.Name] = rows
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// writeExportCSV writes every table with a "table" column in front.
+func writeExportCSV(w io.Writer, tables []chunklog.ExportTable) error {
+	cw := csv.NewWriter(w)
+	for _, t := range tables {
+		if err := cw.Write(append([]string{"table"}, t.Columns...)); err != nil {
+			return err
+		}
+		for _, row := range t.Rows {
+			rec := []string{t.Name}
+			for _, v := range row {
+				if v = exportValue(v); v == nil {
+					rec = append(rec, "")
+				} else {
+					rec = append(rec, fmt.Sprint(v))
+				}
+			}
+			if err := cw.Write(rec); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportValue converts database values without a readable representation.
+func exportValue(v any) any {
+	switch v := v.(type) {
+	case [16]byte:
+		return uuid.UUID(v).String()
+	case netip.Prefix:
+		if v.IsSingleIP() {
+			return v.Addr().String()
+		}
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return v
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uamana/hserv/internal/chunklog"
+	"github.com/uamana/hserv/internal/hserv"
+)
+
+// TestPrivacyExportSubject writes a chunk event of a listener authenticated
+// by a token and exports it by the token subject. It needs a database
+// migrated with scripts/sql in HSERV_TEST_DB.
+func TestPrivacyExportSubject(t *testing.T) {
+	connStr := os.Getenv("HSERV_TEST_DB")
+	if connStr == "" {
+		t.Skip("HSERV_TEST_DB not set")
+	}
+	ctx := context.Background()
+	subject := "listener-" + uuid.NewString() + "@example.com"
+	path := "/srv/hls/mp3_hifi_1700000000_10.0_1.ts"
+
+	w, err := chunklog.NewWriter(ctx, chunklog.Config{
+		ConnString:   connStr,
+		WorkerCount:  1,
+		BatchSize:    1,
+		BatchTimeout: 10 * time.Millisecond,
+		ChannelCap:   1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Send(chunklog.ChunkEvent{
+		Time:      time.Now(),
+		Path:      path,
+		IP:        "192.0.2.1:40000",
+		UserAgent: "player",
+		SID:       uuid.NewString(),
+		UID:       hserv.SubjectUID(subject),
+		ChunkSize: 1024,
+	})
+	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	w.Shutdown(shutdownCtx)
+	t.Cleanup(func() {
+		if err := runPrivacy([]string{"erase", "-subject", subject, "-db", connStr, "-reason", "test cleanup"}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	out := filepath.Join(t.TempDir(), "export.json")
+	err = runPrivacy([]string{"export", "-subject", subject, "-db", connStr, "-reason", "test", "-o", out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export map[string][]map[string]any
+	if err := json.Unmarshal(data, &export); err != nil {
+		t.Fatal(err)
+	}
+	rows := export["chunk_requests"]
+	if len(rows) != 1 || rows[0]["path"] != path {
+		t.Fatalf("export of subject %q = %s, want one row of %s", subject, data, path)
+	}
+}
+
+func TestPrivacyUIDOrSubject(t *testing.T) {
+	for _, args := range [][]string{
+		{"export"},
+		{"export", "-uid", uuid.NewString(), "-subject", "alice@example.com"},
+		{"erase", "-uid", "not-a-uuid"},
+	} {
+		if err := runPrivacy(append(args, "-db", "postgres://unused")); err == nil {
+			t.Errorf("runPrivacy(%q) succeeded", args)
+		}
+	}
+}
@@ -14,6 +14,7 @@ type BatchBuffer struct {
 	buf    []DBEvent
 	err    error
 	parser *useragent.Parser
+	geo    *geoEnricher
 }
 
 func NewBatchBuffer(size int) *BatchBuffer {
@@ -73,6 +74,17 @@ func (b *BatchBuffer) Values() ([]interface{}, error) {
 		c.UAIsSamsungBrowser,
 		c.UAIsVivaldi,
 		c.UAIsYandexBrowser,
+		c.ClientSubject,
+		c.RateLimited,
+		c.Country,
+		c.Region,
+		c.City,
+		c.ASN,
+		c.ISP,
+		c.MergedUIDs,
+		c.SIDStatus,
+		c.Claims,
+		c.Suspect,
 	}, nil
 }
 
@@ -92,6 +104,7 @@ func (b *BatchBuffer) Add(event ChunkEvent) {
 		return
 	}
 	parseEvent(&event, &b.buf[b.wIdx], b.parser)
+	b.geo.enrich(&b.buf[b.wIdx])
 	b.wIdx++
 }
 
@@ -22,6 +22,24 @@ type ChunkEvent struct {
 	SID       string
 	UID       string
 	ChunkSize int64
+	// ClientSubject is the subject of the verified TLS client certificate.
+	ClientSubject string
+	// RateLimited marks requests rejected by a rate limit; they are logged
+	// but not served.
+	RateLimited bool
+	// Country is the ISO country code of the client, if known.
+	Country string
+	// MergedUIDs are the other uids the request carried when its identity
+	// sources disagreed.
+	MergedUIDs []string
+	// SIDStatus is the status of a signed sid: valid, invalid or expired.
+	// Requests with invalid or expired sids are logged but not served.
+	SIDStatus string
+	// Claims are the selected token claims of an authenticated listener.
+	Claims map[string]string
+	// Suspect is the reason the restream detector flagged the sid or uid
+	// for, if any.
+	Suspect string
 }
 
 type ChunkQuality byte
@@ -141,6 +159,17 @@ var chunkRequestColumns = []string{
 	"ua_is_samsung_browser",
 	"ua_is_vivaldi",
 	"ua_is_yandex_browser",
+	"client_subject",
+	"rate_limited",
+	"country",
+	"region",
+	"city",
+	"asn",
+	"isp",
+	"merged_uids",
+	"sid_status",
+	"claims",
+	"suspect",
 }
 
 type DBEvent struct {
@@ -180,6 +209,17 @@ type DBEvent struct {
 	UAIsSamsungBrowser bool
 	UAIsVivaldi        bool
 	UAIsYandexBrowser  bool
+	ClientSubject      string
+	RateLimited        bool
+	Country            string
+	Region             string
+	City               string
+	ASN                int64
+	ISP                string
+	MergedUIDs         []string
+	SIDStatus          string
+	Claims             map[string]string
+	Suspect            string
 }
 
 func parseEvent(event *ChunkEvent, dbEvent *DBEvent, parser *useragent.Parser) {
@@ -191,13 +231,20 @@ func parseEvent(event *ChunkEvent, dbEvent *DBEvent, parser *useragent.Parser) {
 	dbEvent.Time = event.Time
 	dbEvent.Path = event.Path
 
-	if strings.Contains(event.IP, ":") {
-		dbEvent.IP = net.ParseIP(strings.Split(event.IP, ":")[0])
+	if host, _, err := net.SplitHostPort(event.IP); err == nil {
+		dbEvent.IP = net.ParseIP(host)
 	} else {
 		dbEvent.IP = net.ParseIP(event.IP)
 	}
 
 	dbEvent.Referer = event.Referer
+	dbEvent.ClientSubject = event.ClientSubject
+	dbEvent.RateLimited = event.RateLimited
+	dbEvent.Country = event.Country
+	dbEvent.MergedUIDs = event.MergedUIDs
+	dbEvent.SIDStatus = event.SIDStatus
+	dbEvent.Claims = event.Claims
+	dbEvent.Suspect = event.Suspect
 
 	sid, err := uuid.Parse(event.SID)
 	if err != nil {
@@ -294,3 +341,17 @@ func parseEvent(event *ChunkEvent, dbEvent *DBEvent, parser *useragent.Parser) {
 		dbEvent.ChunkDuration = 0
 	}
 }
+
+// ChunkDuration returns the media duration encoded in a chunk file name, or
+// 0 if the name does not follow the chunk name format.
+func ChunkDuration(path string) time.Duration {
+	parts := strings.Split(filepath.Base(path), "_")
+	if len(parts) != 5 {
+		return 0
+	}
+	d, err := strconv.ParseFloat(parts[3], 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(d * float64(time.Second))
+}
@@ -0,0 +1,134 @@
+package chunklog
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/uamana/hserv/internal/geoip"
+)
+
+// geoCacheSize bounds the per-IP lookup cache; it is cleared when full.
+const geoCacheSize = 100_000
+
+// geoEnricher adds location and network data to events. Lookups are cached
+// per IP, and the databases are reopened when their files are replaced.
+// It is shared by all workers.
+type geoEnricher struct {
+	mu    sync.Mutex
+	geo   *geoip.DB
+	asn   *geoip.DB
+	cache map[netip.Addr]geoip.Location
+}
+
+// newGeoEnricher opens the databases. It returns nil if neither is set.
+func newGeoEnricher(geoPath, asnPath string) (*geoEnricher, error) {
+	if geoPath == "" && asnPath == "" {
+		return nil, nil
+	}
+	e := &geoEnricher{cache: make(map[netip.Addr]geoip.Location)}
+	var err error
+	if geoPath != "" {
+		if e.geo, err = geoip.Open(geoPath); err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		if e.asn, err = geoip.Open(asnPath); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// enrich fills the location columns of ev from its IP. The country already
+// resolved by the server, if any, is kept.
+func (e *geoEnricher) enrich(ev *DBEvent) {
+	if e == nil {
+		return
+	}
+	ip, ok := netip.AddrFromSlice(ev.IP)
+	if !ok {
+		return
+	}
+	ip = ip.Unmap()
+
+	e.mu.Lock()
+	loc, ok := e.cache[ip]
+	if !ok {
+		loc = e.lookup(ip)
+		if len(e.cache) >= geoCacheSize {
+			clear(e.cache)
+		}
+		e.cache[ip] = loc
+	}
+	e.mu.Unlock()
+
+	if ev.Country == "" {
+		ev.Country = loc.Country
+	}
+	ev.Region = loc.Region
+	ev.City = loc.City
+	ev.ASN = int64(loc.ASN)
+	ev.ISP = loc.ISP
+}
+
+func (e *geoEnricher) lookup(ip netip.Addr) geoip.Location {
+	var loc geoip.Location
+	if e.geo != nil {
+		loc = e.geo.Lookup(ip)
+	}
+	if e.asn != nil {
+		asn := e.asn.Lookup(ip)
+		loc.ASN, loc.ISP = asn.ASN, asn.ISP
+		if loc.Country == "" {
+			loc.Country = asn.Country
+		}
+	}
+	return loc
+}
+
+// watch reopens databases whose files changed every interval until ctx is
+// done. A database that fails to load is kept.
+func (e *geoEnricher) watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			e.refresh()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *geoEnricher) refresh() {
+	e.mu.Lock()
+	geo, asn := e.geo, e.asn
+	e.mu.Unlock()
+
+	reopen := func(db *geoip.DB) *geoip.DB {
+		if db == nil || !db.Changed() {
+			return db
+		}
+		next, err := geoip.Open(db.Path())
+		if err != nil {
+			slog.Error("keeping old geo database that could not be loaded", "path", db.Path(), "error", err)
+			return db
+		}
+		slog.Info("reloaded geo database", "path", db.Path())
+		return next
+	}
+	nextGeo, nextASN := reopen(geo), reopen(asn)
+	if nextGeo == geo && nextASN == asn {
+		return
+	}
+
+	e.mu.Lock()
+	e.geo, e.asn = nextGeo, nextASN
+	clear(e.cache)
+	e.mu.Unlock()
+}
@@ -0,0 +1,145 @@
+package chunklog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// uidTables are the tables holding per-uid data, all with a uid column.
+var uidTables = []string{"chunk_requests"}
+
+// anonymizeColumns are reset to their defaults when the rows of a uid are
+// anonymized instead of deleted; the uid itself is set to the nil UUID.
+var anonymizeColumns = []string{"ip", "referer", "client_subject", "region", "city", "merged_uids", "claims"}
+
+// PrivacyRequest describes a data subject request for the audit log.
+// StoredUIDs are the values the uid is stored as, e.g. its pseudonyms; if
+// empty, the uid itself is matched.
+type PrivacyRequest struct {
+	UID        uuid.UUID
+	StoredUIDs []uuid.UUID
+	Operator   string
+	Reason     string
+}
+
+func (r *PrivacyRequest) stored() []uuid.UUID {
+	if len(r.StoredUIDs) == 0 {
+		return []uuid.UUID{r.UID}
+	}
+	return r.StoredUIDs
+}
+
+// LoggedTimeRange returns the time of the first and last logged chunk
+// request, zero if there are none.
+func LoggedTimeRange(ctx context.Context, conn *pgx.Conn) (first, last time.Time, err error) {
+	var min, max *time.Time
+	err = conn.QueryRow(ctx, "SELECT min(time), max(time) FROM chunk_requests").Scan(&min, &max)
+	if err != nil || min == nil || max == nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return *min, *max, nil
+}
+
+// ExportTable is the exported data of one table.
+type ExportTable struct {
+	Name    string
+	Columns []string
+	Rows    [][]any
+}
+
+// ExportUID returns all rows of every uid table for the uid, ordered by
+// time. The export is recorded in the audit log.
+func ExportUID(ctx context.Context, conn *pgx.Conn, req PrivacyRequest) ([]ExportTable, error) {
+	var (
+		result []ExportTable
+		total  int64
+	)
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		for _, table := range uidTables {
+			rows, err := tx.Query(ctx, fmt.Sprintf("SELECT * FROM %s WHERE uid = ANY($1) ORDER BY time",
+				pgx.Identifier{table}.Sanitize()), req.stored())
+			if err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			t := ExportTable{Name: table}
+			for _, fd := range rows.FieldDescriptions() {
+				t.Columns = append(t.Columns, fd.Name)
+			}
+			t.Rows, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) ([]any, error) {
+				return row.Values()
+			})
+			if err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			result = append(result, t)
+			total += int64(len(t.Rows))
+		}
+		return audit(ctx, tx, "export", req, total)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// EraseUID deletes the rows of the uid from every uid table, or anonymizes
+// them so aggregate statistics are kept. It returns the number of affected
+// rows. The erasure is recorded in the audit log.
+func EraseUID(ctx context.Context, conn *pgx.Conn, req PrivacyRequest, anonymize bool) (int64, error) {
+	if req.UID == uuid.Nil {
+		return 0, errors.New("refusing to erase the nil uid")
+	}
+	action := "erase"
+	if anonymize {
+		action = "anonymize"
+	}
+	var total int64
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		for _, table := range uidTables {
+			sql := fmt.Sprintf("DELETE FROM %s WHERE uid = ANY($1)", pgx.Identifier{table}.Sanitize())
+			args := []any{req.stored()}
+			if anonymize {
+				set := "uid = $2"
+				for _, col := range anonymizeColumns {
+					set += ", " + pgx.Identifier{col}.Sanitize() + " = DEFAULT"
+				}
+				sql = fmt.Sprintf("UPDATE %s SET %s WHERE uid = ANY($1)", pgx.Identifier{table}.Sanitize(), set)
+				args = append(args, uuid.Nil)
+			}
+			tag, err := tx.Exec(ctx, sql, args...)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			total += tag.RowsAffected()
+		}
+		// Rows of other uids may still name this one as merged.
+		for _, uid := range req.stored() {
+			_, err := tx.Exec(ctx,
+				"UPDATE chunk_requests SET merged_uids = array_remove(merged_uids, $1) WHERE $1 = ANY(merged_uids)",
+				uid.String())
+			if err != nil {
+				return fmt.Errorf("chunk_requests: %w", err)
+			}
+		}
+		return audit(ctx, tx, action, req, total)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+func audit(ctx context.Context, tx pgx.Tx, action string, req PrivacyRequest, rows int64) error {
+	_, err := tx.Exec(ctx,
+		"INSERT INTO privacy_audit (time, action, uid, rows, operator, reason) VALUES (now(), $1, $2, $3, $4, $5)",
+		action, req.UID, rows, req.Operator, req.Reason)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
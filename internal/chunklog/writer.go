@@ -18,14 +18,21 @@ type Config struct {
 	BatchSize    int
 	BatchTimeout time.Duration
 	ConnString   string
+	// GeoDB and ASNDB are optional IP databases used to add location and
+	// network columns; they are checked for changes every GeoRefresh.
+	GeoDB      string
+	ASNDB      string
+	GeoRefresh time.Duration
 }
 
 // Writer consumes chunk events from a buffered channel via worker goroutines.
 type Writer struct {
 	events      chan ChunkEvent
 	pool        *pgxpool.Pool
+	geo         *geoEnricher
 	wg          sync.WaitGroup
-	once        sync.Once
+	closeMu     sync.RWMutex
+	closed      bool
 	drops       atomic.Uint64
 	flushErrors atomic.Uint64
 	ctx         context.Context
@@ -35,6 +42,10 @@ type Writer struct {
 // NewWriter starts the worker pool and returns a Writer.
 // pgxpool is initialized here; conn string comes from config.
 func NewWriter(ctx context.Context, cfg Config) (*Writer, error) {
+	geo, err := newGeoEnricher(cfg.GeoDB, cfg.ASNDB)
+	if err != nil {
+		return nil, err
+	}
 	pool, err := pgxpool.New(ctx, cfg.ConnString)
 	if err != nil {
 		return nil, err
@@ -42,7 +53,10 @@ func NewWriter(ctx context.Context, cfg Config) (*Writer, error) {
 
 	events := make(chan ChunkEvent, cfg.ChannelCap)
 	ctx, cancel := context.WithCancel(ctx)
-	w := &Writer{events: events, pool: pool, ctx: ctx, cancel: cancel}
+	w := &Writer{events: events, pool: pool, geo: geo, ctx: ctx, cancel: cancel}
+	if geo != nil && cfg.GeoRefresh > 0 {
+		go geo.watch(ctx, cfg.GeoRefresh)
+	}
 
 	for i := 0; i < cfg.WorkerCount; i++ {
 		w.wg.Add(1)
@@ -52,8 +66,15 @@ func NewWriter(ctx context.Context, cfg Config) (*Writer, error) {
 	return w, nil
 }
 
-// Send enqueues an event. Non-blocking; returns false if channel is full (event dropped).
+// Send enqueues an event. Non-blocking; returns false if channel is full or
+// the writer is shut down (event dropped). Safe to call after Shutdown.
 func (w *Writer) Send(e ChunkEvent) bool {
+	w.closeMu.RLock()
+	defer w.closeMu.RUnlock()
+	if w.closed {
+		w.drops.Add(1)
+		return false
+	}
 	select {
 	case w.events <- e:
 		return true
@@ -67,9 +88,12 @@ func (w *Writer) Send(e ChunkEvent) bool {
 // The internal context is cancelled only after workers finish (or the deadline
 // expires), so that final flush operations can still reach the database.
 func (w *Writer) Shutdown(ctx context.Context) {
-	w.once.Do(func() {
+	w.closeMu.Lock()
+	if !w.closed {
+		w.closed = true
 		close(w.events)
-	})
+	}
+	w.closeMu.Unlock()
 
 	done := make(chan struct{})
 	go func() {
@@ -85,7 +109,7 @@ func (w *Writer) Shutdown(ctx context.Context) {
 	w.pool.Close()
 }
 
-// Drops returns the number of events dropped due to channel full.
+// Drops returns the number of events dropped due to channel full or shutdown.
 func (w *Writer) Drops() uint64 {
 	return w.drops.Load()
 }
@@ -112,6 +136,7 @@ func (w *Writer) worker(cfg Config, id int) {
 	defer w.wg.Done()
 	logger := slog.With("worker", id)
 	batch := NewBatchBuffer(cfg.BatchSize)
+	batch.geo = w.geo
 	timer := time.NewTimer(cfg.BatchTimeout)
 	timer.Stop()
 	defer timer.Stop()
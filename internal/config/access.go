@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Access holds IP and country access rules, applied in this order: a client
+// IP in Deny is rejected, in Allow accepted; a country in DenyCountries is
+// rejected, in AllowCountries accepted. If Allow or AllowCountries is set,
+// clients matching neither are rejected; otherwise they are accepted.
+// Countries are resolved from CountryDB, a MaxMind DB (.mmdb) or CSV file.
+// Claims rules are checked after these for authenticated listeners.
+type Access struct {
+	Allow          List        `json:"allow"`
+	Deny           List        `json:"deny"`
+	CountryDB      string      `json:"countryDB"`
+	AllowCountries List        `json:"allowCountries"`
+	DenyCountries  List        `json:"denyCountries"`
+	Claims         []ClaimRule `json:"claims"`
+}
+
+// Prefixes parses the Allow and Deny lists. Single addresses are accepted
+// as well as CIDR prefixes.
+func (a *Access) Prefixes() (allow, deny []netip.Prefix, err error) {
+	if allow, err = parsePrefixes(a.Allow); err != nil {
+		return nil, nil, err
+	}
+	if deny, err = parsePrefixes(a.Deny); err != nil {
+		return nil, nil, err
+	}
+	return allow, deny, nil
+}
+
+func parsePrefixes(list List) ([]netip.Prefix, error) {
+	result := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			addr = addr.Unmap()
+			result = append(result, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		if p.Addr().Is4In6() {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		result = append(result, p.Masked())
+	}
+	return result, nil
+}
+
+func (a *Access) validate() error {
+	if _, _, err := a.Prefixes(); err != nil {
+		return err
+	}
+	if err := validateClaimRules(a.Claims); err != nil {
+		return err
+	}
+	if (len(a.AllowCountries) > 0 || len(a.DenyCountries) > 0) && a.CountryDB == "" {
+		return fmt.Errorf("country rules need a country database")
+	}
+	return nil
+}
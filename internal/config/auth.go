@@ -0,0 +1,66 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Auth enables JWT authentication of listeners. Tokens are read from the
+// Authorization header ("Bearer <token>"), the cookie named Cookie or the
+// QueryParam query parameter, and verified with the HMAC key of HMACKeyFile
+// (HS256) or the public keys of PublicKeyFiles (PEM keys or certificates) and
+// JWKSFile (RS256, ES256). The subject claim becomes the uid (identity source
+// "auth") and the claims listed in Claims are stored with chunk events.
+//
+// A request with an invalid token gets 401. With Required, so does a
+// playlist request without a token. Tokens must have an exp claim unless
+// AllowNoExpiry is set.
+type Auth struct {
+	Algorithms     List   `json:"algorithms"`
+	HMACKeyFile    string `json:"hmacKeyFile"`
+	PublicKeyFiles List   `json:"publicKeyFiles"`
+	JWKSFile       string `json:"jwksFile"`
+	Issuer         string `json:"issuer"`
+	Audience       string `json:"audience"`
+	Cookie         string `json:"cookie"`
+	QueryParam     string `json:"queryParam"`
+	Claims         List   `json:"claims"`
+	Required       bool   `json:"required"`
+	AllowNoExpiry  bool   `json:"allowNoExpiry"`
+}
+
+// Enabled reports whether any verification key is configured.
+func (a *Auth) Enabled() bool {
+	return a.HMACKeyFile != "" || len(a.PublicKeyFiles) > 0 || a.JWKSFile != ""
+}
+
+func (a *Auth) validate() error {
+	for _, alg := range a.Algorithms {
+		switch alg {
+		case "HS256", "RS256", "ES256":
+		default:
+			return fmt.Errorf("unsupported JWT algorithm %q", alg)
+		}
+	}
+	if a.Required && !a.Enabled() {
+		return errors.New("required authentication needs a JWT key")
+	}
+	return nil
+}
+
+// ClaimRule restricts the streams under a URL path prefix to listeners whose
+// token has, for every listed claim, one of the listed values.
+type ClaimRule struct {
+	Path   string          `json:"path"`
+	Claims map[string]List `json:"claims"`
+}
+
+func validateClaimRules(rules []ClaimRule) error {
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule.Path, "/") {
+			return fmt.Errorf("claim rule path %q must start with /", rule.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package config
+
+import "errors"
+
+// ChunkCache keeps recently served chunk files in memory, up to SizeMB
+// mebibytes in total. Chunks larger than a sixteenth of it are always served
+// from disk. Zero disables the cache.
+type ChunkCache struct {
+	SizeMB int `json:"sizeMB"`
+}
+
+// Bytes returns the cache size in bytes.
+func (c *ChunkCache) Bytes() int64 {
+	return int64(c.SizeMB) << 20
+}
+
+func (c *ChunkCache) validate() error {
+	if c.SizeMB < 0 {
+		return errors.New("chunk cache size must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,637 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds hserv settings. Values come from the defaults, then the optional
+// JSON config file (-config), then command line flags, so flags always win.
+//
+// Fields tagged `reload:"restart"` are read once on startup; changing them in
+// the config file has no effect until hserv is restarted.
+type Config struct {
+	ConfigPath string `json:"-"`
+
+	Addr         string `json:"addr" reload:"restart"`
+	HTTPAddr     string `json:"httpAddr" reload:"restart"`
+	HTTPMode     string `json:"httpMode"`
+	RedirectPort int    `json:"httpsPort"`
+	HSTS         HSTS   `json:"hsts"`
+	AdminAddr    string `json:"admin" reload:"restart"`
+	RootDir      string `json:"root" reload:"restart"`
+	LogLevel     string `json:"logLevel"`
+
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	ShutdownDrain   Duration `json:"shutdownDrain"`
+
+	ReadTimeout       Duration `json:"readTimeout" reload:"restart"`
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout" reload:"restart"`
+	WriteTimeout      Duration `json:"writeTimeout" reload:"restart"`
+	IdleTimeout       Duration `json:"idleTimeout" reload:"restart"`
+	MaxConns          int      `json:"maxConns"`
+	MaxConnsPerIP     int      `json:"maxConnsPerIP"`
+
+	SidName    string `json:"sid"`
+	UidName    string `json:"uid"`
+	ChunkExt   string `json:"ext"`
+	ChunkMIME  string `json:"mime"`
+	BufferSize int    `json:"bsize"`
+
+	TLSCertPath string     `json:"cert"`
+	TLSKeyPath  string     `json:"key"`
+	Certs       []CertPair `json:"certs"`
+	CertDir     string     `json:"certDir"`
+
+	CertWatchInterval  Duration `json:"certWatch" reload:"restart"`
+	CertExpiryWarnDays int      `json:"certExpiryWarnDays"`
+
+	Access    Access    `json:"access"`
+	Referer   Referer   `json:"referer"`
+	CORS      CORS      `json:"cors"`
+	Privacy   Privacy   `json:"privacy"`
+	UIDCookie UIDCookie `json:"uidCookie"`
+	// IdentityOrder is the precedence of uid sources, see IdentityAuth.
+	IdentityOrder List        `json:"identityOrder"`
+	SIDSigning    SIDSigning  `json:"signedSid"`
+	Auth          Auth        `json:"auth"`
+	RateLimit     RateLimits  `json:"rateLimit"`
+	StreamLimit   StreamLimit `json:"streamLimit"`
+	Restream      Restream    `json:"restream"`
+	Pacing        Pacing      `json:"pacing"`
+	ChunkCache    ChunkCache  `json:"chunkCache"`
+	Origin        Origin      `json:"origin" reload:"restart"`
+	Source        Source      `json:"source" reload:"restart"`
+
+	TLS  TLS  `json:"tls"`
+	ACME ACME `json:"acme" reload:"restart"`
+
+	DBConnString string   `json:"db" reload:"restart"`
+	WorkerCount  int      `json:"workers" reload:"restart"`
+	BatchSize    int      `json:"batch" reload:"restart"`
+	BatchTimeout Duration `json:"batchTimeout" reload:"restart"`
+	ChannelCap   int      `json:"channelCap" reload:"restart"`
+	GeoDB        string   `json:"geoDB" reload:"restart"`
+	ASNDB        string   `json:"asnDB" reload:"restart"`
+	GeoRefresh   Duration `json:"geoRefresh" reload:"restart"`
+}
+
+// CertPair is a TLS certificate and its private key.
+type CertPair struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// Modes of the plain HTTP listener.
+const (
+	HTTPModeRedirect = "redirect"
+	HTTPModeServe    = "serve"
+)
+
+// Keys rate limits are counted by.
+const (
+	RateKeyIP  = "ip"
+	RateKeyUID = "uid"
+)
+
+// RateLimits configures request rate limits for playlists and chunks.
+type RateLimits struct {
+	Playlist RateLimit `json:"playlist"`
+	Chunk    RateLimit `json:"chunk"`
+}
+
+// RateLimit is a token bucket: Rate requests per second on average, up to
+// Burst at once, counted per client IP or uid (falling back to the IP for
+// requests without a uid). A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+	Key   string  `json:"key"`
+}
+
+func (r *RateLimit) validate(name string) error {
+	if r.Rate < 0 || r.Burst < 0 {
+		return fmt.Errorf("%s rate limit must not be negative", name)
+	}
+	if r.Key != RateKeyIP && r.Key != RateKeyUID {
+		return fmt.Errorf("invalid %s rate limit key %q", name, r.Key)
+	}
+	return nil
+}
+
+// What happens to a new session of a uid that has too many.
+const (
+	StreamModeReject = "reject"
+	StreamModeEvict  = "evict"
+)
+
+// StreamLimit limits the concurrent sessions per uid. A session starts with a
+// playlist request and stays alive while its chunks arrive within SessionTTL.
+// Requests with a verified client certificate subject listed in ClientCerts,
+// or a partner token (query parameter PartnerParam) listed in Partners, get
+// that limit instead of MaxPerUID. Zero means unlimited.
+type StreamLimit struct {
+	MaxPerUID    int            `json:"maxPerUid"`
+	Mode         string         `json:"mode"`
+	SessionTTL   Duration       `json:"sessionTTL"`
+	PartnerParam string         `json:"partnerParam"`
+	Partners     map[string]int `json:"partners"`
+	ClientCerts  map[string]int `json:"clientCerts"`
+}
+
+// Enabled reports whether sessions are limited at all.
+func (s *StreamLimit) Enabled() bool {
+	return s.MaxPerUID > 0 || len(s.Partners) > 0 || len(s.ClientCerts) > 0
+}
+
+func (s *StreamLimit) validate() error {
+	if s.Mode != StreamModeReject && s.Mode != StreamModeEvict {
+		return fmt.Errorf("invalid stream limit mode %q", s.Mode)
+	}
+	if s.SessionTTL.Duration <= 0 {
+		return errors.New("session TTL must be greater than 0")
+	}
+	return nil
+}
+
+// HSTS configures the Strict-Transport-Security header sent on TLS
+// responses. It is disabled when MaxAge is zero.
+type HSTS struct {
+	MaxAge            Duration `json:"maxAge"`
+	IncludeSubDomains bool     `json:"includeSubDomains"`
+	Preload           bool     `json:"preload"`
+}
+
+// ACME configures automatic certificate management. It is enabled when
+// Domains is not empty.
+type ACME struct {
+	Domains      List   `json:"domains"`
+	Email        string `json:"email"`
+	DirectoryURL string `json:"directoryURL"`
+	// CABundle is a PEM file of roots trusted for the directory URL, e.g. the
+	// root of a local test server.
+	CABundle string `json:"caBundle"`
+	CacheDir string `json:"cacheDir"`
+}
+
+// Enabled reports whether ACME certificate management is configured.
+func (a *ACME) Enabled() bool {
+	return len(a.Domains) > 0
+}
+
+// Default returns the configuration used when neither a config file nor
+// flags override a setting.
+func Default() *Config {
+	return &Config{
+		Addr:       ":6443",
+		HTTPMode:   HTTPModeRedirect,
+		RootDir:    ".",
+		LogLevel:   "info",
+		SidName:    "sid",
+		UidName:    "uid",
+		ChunkExt:   ".ts",
+		ChunkMIME:  "video/mp2t",
+		BufferSize: 1024,
+
+		ShutdownTimeout: Duration{5 * time.Second},
+
+		ReadHeaderTimeout: Duration{10 * time.Second},
+		IdleTimeout:       Duration{2 * time.Minute},
+
+		CertWatchInterval:  Duration{30 * time.Second},
+		CertExpiryWarnDays: 14,
+
+		CORS: CORS{CORSPolicy: CORSPolicy{
+			AllowOrigins: List{"*"},
+			AllowHeaders: List{"*"},
+		}},
+		UIDCookie: UIDCookie{
+			Path:   "/",
+			MaxAge: Duration{365 * 24 * time.Hour},
+		},
+		IdentityOrder: List{IdentityAuth, IdentityCookie, IdentityQuery},
+		SIDSigning:    SIDSigning{MaxAge: Duration{24 * time.Hour}},
+		Auth: Auth{
+			Algorithms: List{"HS256", "RS256", "ES256"},
+			QueryParam: "token",
+		},
+		Privacy: Privacy{
+			CookieMode:   CookieModeAlways,
+			SaltRotation: Duration{24 * time.Hour},
+		},
+		RateLimit: RateLimits{
+			Playlist: RateLimit{Burst: 10, Key: RateKeyIP},
+			Chunk:    RateLimit{Burst: 10, Key: RateKeyIP},
+		},
+		StreamLimit: StreamLimit{
+			Mode:         StreamModeReject,
+			SessionTTL:   Duration{30 * time.Second},
+			PartnerParam: "partner",
+		},
+		Restream: Restream{Window: Duration{10 * time.Minute}},
+		Pacing:   Pacing{InitialBytes: 64 << 10},
+		Origin: Origin{
+			PlaylistTTL: Duration{time.Second},
+			RetainFor:   Duration{10 * time.Minute},
+			Timeout:     Duration{10 * time.Second},
+		},
+		Source: Source{
+			Type: SourceDir,
+			S3:   S3Source{Region: "us-east-1", Timeout: Duration{30 * time.Second}},
+		},
+		TLS: TLS{
+			MinVersion: "1.2",
+			ClientAuth: "none",
+		},
+		ACME: ACME{
+			DirectoryURL: "https://acme-v02.api.letsencrypt.org/directory",
+			CacheDir:     "acme-cache",
+		},
+		BatchSize:    1000,
+		BatchTimeout: Duration{200 * time.Millisecond},
+		GeoRefresh:   Duration{time.Minute},
+	}
+}
+
+// BindFlags registers a flag for every command line configurable setting.
+// Flag defaults are the current values of c.
+func (c *Config) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ConfigPath, "config", c.ConfigPath, "path to the JSON config file")
+	fs.StringVar(&c.Addr, "addr", c.Addr, "address to listen on")
+	fs.StringVar(&c.HTTPAddr, "httpaddr", c.HTTPAddr, "address of the plain HTTP listener (disabled if empty)")
+	fs.StringVar(&c.HTTPMode, "httpmode", c.HTTPMode, "plain HTTP listener mode: redirect (to HTTPS) or serve")
+	fs.IntVar(&c.RedirectPort, "httpsport", c.RedirectPort, "public HTTPS port used in redirects (0 = port of -addr)")
+	fs.Var(&c.HSTS.MaxAge, "hsts", "max-age of the Strict-Transport-Security header (0 disables)")
+	fs.StringVar(&c.AdminAddr, "admin", c.AdminAddr, "address of the admin endpoint (disabled if empty)")
+	fs.Var(&c.ShutdownTimeout, "shutdowntimeout", "maximum time to drain in-flight requests, and then chunk log events, on shutdown")
+	fs.Var(&c.ShutdownDrain, "shutdowndrain", "time between reporting not ready and closing the listeners on shutdown")
+	fs.Var(&c.ReadTimeout, "readtimeout", "maximum duration for reading a request, including the body (0 = none)")
+	fs.Var(&c.ReadHeaderTimeout, "readheadertimeout", "maximum duration for reading request headers (0 = none)")
+	fs.Var(&c.WriteTimeout, "writetimeout", "maximum duration for writing a response (0 = none)")
+	fs.Var(&c.IdleTimeout, "idletimeout", "how long an idle keep-alive connection is kept open (0 = none)")
+	fs.IntVar(&c.MaxConns, "maxconns", c.MaxConns, "maximum number of open connections (0 = unlimited)")
+	fs.IntVar(&c.MaxConnsPerIP, "maxconnsperip", c.MaxConnsPerIP, "maximum number of open connections per client IP (0 = unlimited)")
+	fs.StringVar(&c.RootDir, "root", c.RootDir, "root directory to serve")
+	fs.StringVar(&c.LogLevel, "loglevel", c.LogLevel, "log level: debug, info, warn or error")
+	fs.StringVar(&c.SidName, "sid", c.SidName, "name of the sid parameter")
+	fs.StringVar(&c.UidName, "uid", c.UidName, "name of the uid query parameter (and cookie, see -cookiename)")
+	fs.StringVar(&c.ChunkExt, "ext", c.ChunkExt, "extension of the chunk files")
+	fs.StringVar(&c.ChunkMIME, "mime", c.ChunkMIME, "MIME type of the chunk files")
+	fs.IntVar(&c.BufferSize, "bsize", c.BufferSize, "buffer size for the scanner")
+	fs.StringVar(&c.TLSCertPath, "cert", c.TLSCertPath, "path to the TLS certificate")
+	fs.StringVar(&c.TLSKeyPath, "key", c.TLSKeyPath, "path to the TLS key")
+	fs.StringVar(&c.CertDir, "certdir", c.CertDir, "directory of additional <name>.crt/<name>.key pairs selected by SNI")
+	fs.Var(&c.CertWatchInterval, "certwatch", "interval for polling certificate files for changes (0 disables)")
+	fs.IntVar(&c.CertExpiryWarnDays, "certexpirywarn", c.CertExpiryWarnDays, "warn when a certificate expires within this many days (0 disables)")
+	fs.Var(&c.Access.Allow, "allow", "comma separated IPs or CIDRs always allowed")
+	fs.Var(&c.Access.Deny, "deny", "comma separated IPs or CIDRs always denied")
+	fs.StringVar(&c.Access.CountryDB, "countrydb", c.Access.CountryDB, "IP to country database, MaxMind DB (.mmdb) or CSV ranges")
+	fs.Var(&c.Access.AllowCountries, "allowcountries", "comma separated country codes allowed (all others are denied)")
+	fs.Var(&c.Access.DenyCountries, "denycountries", "comma separated country codes denied")
+	fs.Var(&c.Referer.Allow, "referers", "comma separated hosts allowed to embed the streams, *.example.com for subdomains (empty = all)")
+	fs.BoolVar(&c.Referer.AllowEmpty, "allowemptyreferer", c.Referer.AllowEmpty, "allow requests without Origin and Referer when -referers is set")
+	fs.StringVar(&c.Referer.Fallback, "refererfallback", c.Referer.Fallback, "playlist file served to disallowed referers instead of 403")
+	fs.Var(&c.CORS.AllowOrigins, "corsorigins", "comma separated origins allowed to read responses, * for any (empty disables CORS)")
+	fs.BoolVar(&c.CORS.AllowCredentials, "corscredentials", c.CORS.AllowCredentials, "allow credentialed CORS requests, sending the uid cookie")
+	fs.Var(&c.CORS.AllowHeaders, "corsheaders", "comma separated request headers allowed by CORS, * for any")
+	fs.Var(&c.CORS.ExposeHeaders, "corsexpose", "comma separated response headers exposed to CORS requests")
+	fs.Var(&c.CORS.MaxAge, "corsmaxage", "how long browsers may cache a CORS preflight response")
+	fs.StringVar(&c.UIDCookie.Name, "cookiename", c.UIDCookie.Name, "name of the uid cookie (default: the -uid name)")
+	fs.StringVar(&c.UIDCookie.Domain, "cookiedomain", c.UIDCookie.Domain, "Domain attribute of the uid cookie, to share it across subdomains")
+	fs.StringVar(&c.UIDCookie.SameSite, "cookiesamesite", c.UIDCookie.SameSite, "SameSite attribute of the uid cookie: lax, strict or none")
+	fs.Var(&c.UIDCookie.MaxAge, "cookiemaxage", "lifetime of the uid cookie")
+	fs.Var(&c.IdentityOrder, "identityorder", "comma separated precedence of uid sources: auth, cookie, query")
+	fs.BoolVar(&c.SIDSigning.Enabled, "signsids", c.SIDSigning.Enabled, "issue signed sids and reject chunk requests with invalid or expired ones")
+	fs.StringVar(&c.SIDSigning.KeyFile, "sidkey", c.SIDSigning.KeyFile, "file with the sid signing key, shared by all instances (random if empty)")
+	fs.Var(&c.SIDSigning.MaxAge, "sidmaxage", "maximum age of a signed sid")
+	fs.StringVar(&c.Auth.HMACKeyFile, "jwtkey", c.Auth.HMACKeyFile, "file with the HS256 key for listener tokens")
+	fs.Var(&c.Auth.PublicKeyFiles, "jwtpubkeys", "comma separated PEM files with RS256/ES256 public keys or certificates for listener tokens")
+	fs.StringVar(&c.Auth.JWKSFile, "jwks", c.Auth.JWKSFile, "JWKS file with public keys for listener tokens")
+	fs.StringVar(&c.Auth.Issuer, "jwtissuer", c.Auth.Issuer, "required iss claim of listener tokens")
+	fs.StringVar(&c.Auth.Audience, "jwtaudience", c.Auth.Audience, "required aud claim of listener tokens")
+	fs.StringVar(&c.Auth.Cookie, "jwtcookie", c.Auth.Cookie, "name of the cookie holding the listener token")
+	fs.StringVar(&c.Auth.QueryParam, "jwtparam", c.Auth.QueryParam, "name of the query parameter holding the listener token")
+	fs.Var(&c.Auth.Claims, "jwtclaims", "comma separated token claims stored with chunk events")
+	fs.BoolVar(&c.Auth.Required, "jwtrequired", c.Auth.Required, "reject playlist requests without a listener token")
+	fs.BoolVar(&c.Auth.AllowNoExpiry, "jwtallownoexp", c.Auth.AllowNoExpiry, "accept listener tokens without an exp claim")
+	fs.StringVar(&c.Privacy.CookieMode, "cookiemode", c.Privacy.CookieMode, "when to set the uid cookie: always, consent or none")
+	fs.StringVar(&c.Privacy.ConsentCookie, "consentcookie", c.Privacy.ConsentCookie, "name of the cookie that gives consent to the uid cookie")
+	fs.StringVar(&c.Privacy.ConsentHeader, "consentheader", c.Privacy.ConsentHeader, "name of the header that gives consent to the uid cookie")
+	fs.BoolVar(&c.Privacy.HonorDNT, "honordnt", c.Privacy.HonorDNT, "treat DNT and Sec-GPC as refusing the uid cookie")
+	fs.IntVar(&c.Privacy.IPv4Bits, "anonip4", c.Privacy.IPv4Bits, "store and log client IPv4 addresses truncated to this prefix length (0 = full)")
+	fs.IntVar(&c.Privacy.IPv6Bits, "anonip6", c.Privacy.IPv6Bits, "store and log client IPv6 addresses truncated to this prefix length (0 = full)")
+	fs.BoolVar(&c.Privacy.PseudonymizeUID, "pseudouid", c.Privacy.PseudonymizeUID, "store and log keyed hashes of uids instead of uids")
+	fs.StringVar(&c.Privacy.SaltFile, "uidsalt", c.Privacy.SaltFile, "file with the secret for uid hashes, shared by all instances (random if empty)")
+	fs.Var(&c.Privacy.SaltRotation, "uidsaltrotation", "how often the uid hash key rotates")
+	fs.Float64Var(&c.RateLimit.Playlist.Rate, "playlistrate", c.RateLimit.Playlist.Rate, "playlist requests per second per client (0 = unlimited)")
+	fs.IntVar(&c.RateLimit.Playlist.Burst, "playlistburst", c.RateLimit.Playlist.Burst, "playlist request burst per client")
+	fs.Float64Var(&c.RateLimit.Chunk.Rate, "chunkrate", c.RateLimit.Chunk.Rate, "chunk requests per second per client (0 = unlimited)")
+	fs.IntVar(&c.RateLimit.Chunk.Burst, "chunkburst", c.RateLimit.Chunk.Burst, "chunk request burst per client")
+	fs.IntVar(&c.StreamLimit.MaxPerUID, "maxstreams", c.StreamLimit.MaxPerUID, "maximum concurrent sessions per uid (0 = unlimited)")
+	fs.StringVar(&c.StreamLimit.Mode, "streammode", c.StreamLimit.Mode, "when a uid has too many sessions: reject the new one or evict the oldest")
+	fs.Var(&c.StreamLimit.SessionTTL, "sessionttl", "a session is alive while its chunks arrive within this duration")
+	fs.Var(&c.Restream.Window, "restreamwindow", "time window of the restream checks")
+	fs.IntVar(&c.Restream.MaxIPs, "restreammaxips", c.Restream.MaxIPs, "flag sids and uids seen from more client IPs within the window (0 disables)")
+	fs.IntVar(&c.Restream.MaxASNs, "restreammaxasns", c.Restream.MaxASNs, "flag sids and uids seen from more networks within the window (0 disables, needs -asndb)")
+	fs.Float64Var(&c.Restream.MaxSpeed, "restreammaxspeed", c.Restream.MaxSpeed, "flag sids fetching more seconds of media per second (0 disables)")
+	fs.Var(&c.Restream.GeoWindow, "restreamgeowindow", "flag uids changing country within this duration (0 disables, needs -countrydb)")
+	fs.Var(&c.Restream.BlockFor, "restreamblock", "block flagged sids and uids for this duration (0 only flags)")
+	fs.Float64Var(&c.Pacing.Factor, "pacing", c.Pacing.Factor, "send chunks at this multiple of their bitrate (0 = full speed)")
+	fs.IntVar(&c.Pacing.InitialBytes, "pacinginitial", c.Pacing.InitialBytes, "bytes of each chunk sent before pacing starts")
+	fs.Float64Var(&c.Pacing.MaxEgress, "maxegress", c.Pacing.MaxEgress, "total chunk egress cap in Mbit/s (0 = unlimited)")
+	fs.IntVar(&c.ChunkCache.SizeMB, "chunkcache", c.ChunkCache.SizeMB, "memory for recently served chunks in MiB (0 disables)")
+	fs.StringVar(&c.Origin.URL, "origin", c.Origin.URL, "URL of the origin to pull files from into the root directory (edge mode, disabled if empty)")
+	fs.StringVar(&c.Origin.CABundle, "originca", c.Origin.CABundle, "PEM bundle of CAs trusted for the origin")
+	fs.Var(&c.Origin.PlaylistTTL, "originttl", "refetch playlists from the origin when older than this")
+	fs.Var(&c.Origin.RetainFor, "originretain", "remove pulled chunks this long after they were fetched")
+	fs.Var(&c.Origin.Timeout, "origintimeout", "timeout of a request to the origin")
+	fs.StringVar(&c.Source.Type, "source", c.Source.Type, "where to read files from: dir (root directory), memory (root directory loaded at startup), archive or s3")
+	fs.StringVar(&c.Source.Archive, "archive", c.Source.Archive, "zip, tar or tar.gz archive to serve with -source archive")
+	fs.StringVar(&c.Source.S3.Endpoint, "s3endpoint", c.Source.S3.Endpoint, "endpoint URL of the S3-compatible storage to serve with -source s3")
+	fs.StringVar(&c.Source.S3.Bucket, "s3bucket", c.Source.S3.Bucket, "bucket to serve with -source s3")
+	fs.StringVar(&c.Source.S3.Region, "s3region", c.Source.S3.Region, "region of the bucket, used for request signing")
+	fs.Var(&c.Source.S3.Timeout, "s3timeout", "timeout of a request to the bucket")
+	fs.StringVar(&c.TLS.MinVersion, "tlsmin", c.TLS.MinVersion, "minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	fs.Var(&c.TLS.CipherSuites, "tlsciphers", "comma separated TLS 1.2 cipher suites (empty = Go defaults)")
+	fs.Var(&c.TLS.Curves, "tlscurves", "comma separated curve preferences: X25519, P256, P384, P521, X25519MLKEM768")
+	fs.StringVar(&c.TLS.SessionTicketKeys, "tlsticketkeys", c.TLS.SessionTicketKeys, "file with base64 session ticket keys, one per line, first is current")
+	fs.StringVar(&c.TLS.ClientCA, "clientca", c.TLS.ClientCA, "PEM bundle of CAs for client certificates")
+	fs.StringVar(&c.TLS.ClientAuth, "clientauth", c.TLS.ClientAuth, "client certificate policy: none, request, verify (if given) or require")
+	fs.Var(&c.ACME.Domains, "acme", "comma separated domains to obtain ACME certificates for (disabled if empty)")
+	fs.StringVar(&c.ACME.Email, "acmeemail", c.ACME.Email, "contact email for the ACME account")
+	fs.StringVar(&c.ACME.DirectoryURL, "acmedir", c.ACME.DirectoryURL, "ACME directory URL")
+	fs.StringVar(&c.ACME.CABundle, "acmeca", c.ACME.CABundle, "PEM bundle of CAs trusted for the ACME directory")
+	fs.StringVar(&c.ACME.CacheDir, "acmecache", c.ACME.CacheDir, "directory for ACME account keys and certificates")
+	fs.StringVar(&c.DBConnString, "db", c.DBConnString, "connection string for the database")
+	fs.IntVar(&c.WorkerCount, "workers", c.WorkerCount, "number of workers for the chunk log writer")
+	fs.IntVar(&c.BatchSize, "batch", c.BatchSize, "batch size for the chunk log writer")
+	fs.Var(&c.BatchTimeout, "batchtimeout", "batch timeout for the chunk log writer")
+	fs.IntVar(&c.ChannelCap, "channelcap", c.ChannelCap, "channel capacity for the chunk log writer")
+	fs.StringVar(&c.GeoDB, "geodb", c.GeoDB, "IP location database for chunk log events, MaxMind DB (.mmdb) or CSV ranges")
+	fs.StringVar(&c.ASNDB, "asndb", c.ASNDB, "IP to ASN/ISP database for chunk log events, MaxMind DB (.mmdb) or CSV ranges")
+	fs.Var(&c.GeoRefresh, "georefresh", "interval for checking the geo databases for changes (0 disables)")
+}
+
+// Load builds the configuration from command line arguments (without the
+// program name). If -config is given the file is decoded over the defaults
+// and the arguments are parsed a second time so that flags take precedence.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	fs := flag.NewFlagSet("hserv", flag.ContinueOnError)
+	cfg.BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if cfg.ConfigPath != "" {
+		path := cfg.ConfigPath
+		cfg = Default()
+		if err := cfg.decodeFile(path); err != nil {
+			return nil, err
+		}
+		cfg.ConfigPath = path
+		fs = flag.NewFlagSet("hserv", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		cfg.BindFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) decodeFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// normalize fills in derived defaults.
+func (c *Config) normalize() (err error) {
+	c.RootDir, err = filepath.Abs(c.RootDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path of root directory: %w", err)
+	}
+	if c.ChunkMIME == "" {
+		c.ChunkMIME = mime.TypeByExtension(c.ChunkExt)
+	}
+	if c.UIDCookie.Name == "" {
+		c.UIDCookie.Name = c.UidName
+	}
+	if c.DBConnString != "" {
+		if c.WorkerCount <= 0 {
+			c.WorkerCount = runtime.NumCPU()
+		}
+		if c.ChannelCap <= 0 {
+			c.ChannelCap = c.WorkerCount * c.BatchSize * 2
+		}
+	}
+	return nil
+}
+
+// Validate reports the first invalid setting.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Addr == "" && c.HTTPAddr == "" {
+		errs = append(errs, errors.New("addr or httpAddr must be set"))
+	}
+	if c.Addr == "" && c.HTTPMode != HTTPModeServe {
+		errs = append(errs, errors.New("httpMode must be serve when the TLS listener is disabled"))
+	}
+	if c.HTTPMode != HTTPModeRedirect && c.HTTPMode != HTTPModeServe {
+		errs = append(errs, fmt.Errorf("invalid httpMode %q", c.HTTPMode))
+	}
+	if c.SidName == "" || c.UidName == "" {
+		errs = append(errs, errors.New("sid and uid names must not be empty"))
+	}
+	if !strings.HasPrefix(c.ChunkExt, ".") || c.ChunkExt == ".m3u8" {
+		errs = append(errs, fmt.Errorf("invalid chunk extension %q", c.ChunkExt))
+	}
+	if c.BufferSize <= 0 {
+		errs = append(errs, errors.New("buffer size must be greater than 0"))
+	}
+	if (c.TLSCertPath == "") != (c.TLSKeyPath == "") {
+		errs = append(errs, errors.New("TLS certificate and key paths must be given together"))
+	}
+	for _, p := range c.Certs {
+		if p.Cert == "" || p.Key == "" {
+			errs = append(errs, errors.New("every entry of certs needs cert and key"))
+			break
+		}
+	}
+	if c.Addr != "" && !c.HasCertFiles() && !c.ACME.Enabled() {
+		errs = append(errs, errors.New("a TLS certificate (cert/key, certs, certDir or acme) is required"))
+	}
+	if err := c.Access.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Referer.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.CORS.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Privacy.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.UIDCookie.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateIdentityOrder(c.IdentityOrder); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.SIDSigning.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Auth.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.RateLimit.Playlist.validate("playlist"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.RateLimit.Chunk.validate("chunk"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.StreamLimit.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Restream.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Pacing.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.ChunkCache.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Origin.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Source.validate(&c.Origin); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.TLS.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.ACME.Enabled() && (c.ACME.DirectoryURL == "" || c.ACME.CacheDir == "") {
+		errs = append(errs, errors.New("ACME needs a directory URL and a cache directory"))
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("shutdown timeout must be greater than 0"))
+	}
+	if c.ShutdownDrain.Duration < 0 {
+		errs = append(errs, errors.New("shutdown drain must not be negative"))
+	}
+	if c.ReadTimeout.Duration < 0 || c.ReadHeaderTimeout.Duration < 0 || c.WriteTimeout.Duration < 0 || c.IdleTimeout.Duration < 0 {
+		errs = append(errs, errors.New("server timeouts must not be negative"))
+	}
+	if c.MaxConns < 0 || c.MaxConnsPerIP < 0 {
+		errs = append(errs, errors.New("connection limits must not be negative"))
+	}
+	if c.CertWatchInterval.Duration < 0 {
+		errs = append(errs, errors.New("certificate watch interval must not be negative"))
+	}
+	if c.GeoRefresh.Duration < 0 {
+		errs = append(errs, errors.New("geo refresh interval must not be negative"))
+	}
+	if _, err := c.Level(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.DBConnString != "" && c.BatchSize <= 0 {
+		errs = append(errs, errors.New("batch size must be greater than 0 when database connection string is provided"))
+	}
+	return errors.Join(errs...)
+}
+
+// HasCertFiles reports whether any certificate files are configured.
+func (c *Config) HasCertFiles() bool {
+	return c.TLSCertPath != "" || len(c.Certs) > 0 || c.CertDir != ""
+}
+
+// HTTPSPort returns the public HTTPS port used when redirecting plain HTTP
+// requests.
+func (c *Config) HTTPSPort() int {
+	if c.RedirectPort > 0 {
+		return c.RedirectPort
+	}
+	if _, port, err := net.SplitHostPort(c.Addr); err == nil {
+		if p, err := strconv.Atoi(port); err == nil {
+			return p
+		}
+	}
+	return 443
+}
+
+// Level returns the parsed LogLevel.
+func (c *Config) Level() (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return l, fmt.Errorf("invalid log level %q", c.LogLevel)
+	}
+	return l, nil
+}
+
+// Merge returns a copy of next where every restart-only field keeps its value
+// from cur, together with the JSON names of the restart-only fields whose
+// values differ between cur and next. Fields of nested structs are named
+// "parent.field".
+func Merge(cur, next *Config) (*Config, []string) {
+	merged := *next
+	restart := mergeStruct(reflect.ValueOf(cur).Elem(), reflect.ValueOf(&merged).Elem(), "")
+	return &merged, restart
+}
+
+func mergeStruct(cv, mv reflect.Value, prefix string) []string {
+	var restart []string
+	t := mv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := prefix + strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Tag.Get("reload") != "restart" {
+			if f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(Duration{}) {
+				nested := name + "."
+				if f.Anonymous {
+					nested = prefix
+				}
+				restart = append(restart, mergeStruct(cv.Field(i), mv.Field(i), nested)...)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(cv.Field(i).Interface(), mv.Field(i).Interface()) {
+			restart = append(restart, name)
+			mv.Field(i).Set(cv.Field(i))
+		}
+	}
+	return restart
+}
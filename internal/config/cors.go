@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CORS is the cross-origin policy of the streams. The policy of the Streams
+// entry with the longest matching path prefix applies, the top-level policy
+// otherwise.
+type CORS struct {
+	CORSPolicy
+	Streams []StreamCORS `json:"streams"`
+}
+
+// CORSPolicy lists the origins allowed to read responses: "*" for any,
+// exact origins ("https://example.com") or wildcards
+// ("https://*.example.com"). No CORS headers are sent if AllowOrigins is
+// empty. With AllowCredentials (needed to send the uid cookie) the request
+// origin is echoed instead of "*". MaxAge is how long browsers may cache a
+// preflight response.
+type CORSPolicy struct {
+	AllowOrigins     List     `json:"allowOrigins"`
+	AllowCredentials bool     `json:"allowCredentials"`
+	AllowHeaders     List     `json:"allowHeaders"`
+	ExposeHeaders    List     `json:"exposeHeaders"`
+	MaxAge           Duration `json:"maxAge"`
+}
+
+// StreamCORS is the CORS policy of the streams under a URL path prefix.
+type StreamCORS struct {
+	Path string `json:"path"`
+	CORSPolicy
+}
+
+// Policy returns the policy that applies to the URL path.
+func (c *CORS) Policy(path string) *CORSPolicy {
+	if i := longestPrefix(len(c.Streams), func(i int) string { return c.Streams[i].Path }, path); i >= 0 {
+		return &c.Streams[i].CORSPolicy
+	}
+	return &c.CORSPolicy
+}
+
+// AllowsAny reports whether every origin is allowed.
+func (p *CORSPolicy) AllowsAny() bool {
+	for _, o := range p.AllowOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether origin may read responses.
+func (p *CORSPolicy) Allows(origin string) bool {
+	for _, pattern := range p.AllowOrigins {
+		if pattern == "*" || strings.EqualFold(pattern, origin) {
+			return true
+		}
+		if before, after, ok := strings.Cut(pattern, "*"); ok &&
+			len(origin) > len(before)+len(after) &&
+			strings.HasPrefix(strings.ToLower(origin), strings.ToLower(before)) &&
+			strings.HasSuffix(strings.ToLower(origin), strings.ToLower(after)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) validate() error {
+	if err := c.CORSPolicy.validate(); err != nil {
+		return err
+	}
+	for _, s := range c.Streams {
+		if !strings.HasPrefix(s.Path, "/") {
+			return fmt.Errorf("CORS stream path %q must start with /", s.Path)
+		}
+		if err := s.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *CORSPolicy) validate() error {
+	for _, o := range p.AllowOrigins {
+		if o == "*" {
+			continue
+		}
+		if strings.Count(o, "*") > 1 || (strings.Contains(o, "*") && !strings.Contains(o, "://*.")) {
+			return fmt.Errorf("invalid CORS origin %q", o)
+		}
+	}
+	if p.MaxAge.Duration < 0 {
+		return fmt.Errorf("CORS max age must not be negative")
+	}
+	return nil
+}
+
+// longestPrefix returns the index of the entry whose path (given by pathOf)
+// is the longest prefix of path, or -1 if there is none.
+func longestPrefix(n int, pathOf func(int) string, path string) int {
+	found, best := -1, -1
+	for i := range n {
+		if p := pathOf(i); len(p) > best && strings.HasPrefix(path, p) {
+			found, best = i, len(p)
+		}
+	}
+	return found
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Duration is a time.Duration that is written as a string ("200ms", "5s")
+// in the config file and on the command line.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.Set(s)
+}
+
+// Set implements flag.Value.
+func (d *Duration) Set(s string) (err error) {
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
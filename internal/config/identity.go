@@ -0,0 +1,74 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sources of the listener identity (uid).
+const (
+	IdentityAuth   = "auth"
+	IdentityCookie = "cookie"
+	IdentityQuery  = "query"
+)
+
+// UIDCookie holds the attributes of the uid cookie. Name defaults to the uid
+// parameter name; SameSite is "", "lax", "strict" or "none".
+type UIDCookie struct {
+	Name     string   `json:"name"`
+	Domain   string   `json:"domain"`
+	Path     string   `json:"path"`
+	SameSite string   `json:"sameSite"`
+	MaxAge   Duration `json:"maxAge"`
+}
+
+// SameSiteMode returns the parsed SameSite attribute.
+func (c *UIDCookie) SameSiteMode() http.SameSite {
+	switch strings.ToLower(c.SameSite) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteDefaultMode
+}
+
+func (c *UIDCookie) validate() error {
+	switch strings.ToLower(c.SameSite) {
+	case "", "lax", "strict", "none":
+	default:
+		return fmt.Errorf("invalid cookie SameSite %q", c.SameSite)
+	}
+	if c.MaxAge.Duration <= 0 {
+		return errors.New("cookie max age must be greater than 0")
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		return fmt.Errorf("cookie path %q must start with /", c.Path)
+	}
+	return nil
+}
+
+// validateIdentityOrder checks the precedence of identity sources: the first
+// source that has a uid wins, the uids of the others are recorded as merged.
+func validateIdentityOrder(order List) error {
+	if len(order) == 0 {
+		return errors.New("identity order must not be empty")
+	}
+	seen := make(map[string]bool)
+	for _, s := range order {
+		switch s {
+		case IdentityAuth, IdentityCookie, IdentityQuery:
+		default:
+			return fmt.Errorf("invalid identity source %q", s)
+		}
+		if seen[s] {
+			return fmt.Errorf("duplicate identity source %q", s)
+		}
+		seen[s] = true
+	}
+	return nil
+}
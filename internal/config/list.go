@@ -0,0 +1,22 @@
+package config
+
+import "strings"
+
+// List is a list of strings given as a JSON array in the config file and as
+// a comma separated value on the command line.
+type List []string
+
+func (l *List) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set implements flag.Value.
+func (l *List) Set(s string) error {
+	*l = nil
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Origin turns hserv into an edge node that pulls files from an upstream
+// origin at URL instead of serving files of its own. Pulled files are stored
+// below RootDir. Playlists are refetched when older than PlaylistTTL, chunks
+// are fetched once and removed RetainFor after they were fetched; other files
+// below RootDir are left alone. A failed fetch is retried once it is older
+// than PlaylistTTL, a file missing on the origin on the next request. CABundle is a PEM file of roots trusted for the origin,
+// e.g. a self-signed origin.
+type Origin struct {
+	URL         string   `json:"url"`
+	CABundle    string   `json:"caBundle"`
+	PlaylistTTL Duration `json:"playlistTTL"`
+	RetainFor   Duration `json:"retainFor"`
+	Timeout     Duration `json:"timeout"`
+}
+
+// Enabled reports whether hserv pulls its files from an origin.
+func (o *Origin) Enabled() bool {
+	return o.URL != ""
+}
+
+func (o *Origin) validate() error {
+	if !o.Enabled() {
+		return nil
+	}
+	u, err := url.Parse(o.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid origin URL %q", o.URL)
+	}
+	if o.PlaylistTTL.Duration <= 0 || o.RetainFor.Duration <= 0 || o.Timeout.Duration <= 0 {
+		return errors.New("origin playlist TTL, retention and timeout must be greater than 0")
+	}
+	return nil
+}
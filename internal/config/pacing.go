@@ -0,0 +1,28 @@
+package config
+
+import "errors"
+
+// Pacing smooths the egress of chunk responses. Each chunk is sent at Factor
+// times its encoded bitrate (its size divided by the duration in its name),
+// after an unpaced first InitialBytes. All chunk responses together are kept
+// under MaxEgress megabits per second. Zero disables either limit.
+type Pacing struct {
+	Factor       float64 `json:"factor"`
+	InitialBytes int     `json:"initialBytes"`
+	MaxEgress    float64 `json:"maxEgress"`
+}
+
+// Enabled reports whether chunk responses are paced at all.
+func (p *Pacing) Enabled() bool {
+	return p.Factor > 0 || p.MaxEgress > 0
+}
+
+func (p *Pacing) validate() error {
+	if p.Factor != 0 && p.Factor < 1 {
+		return errors.New("pacing factor must be 0 or at least 1")
+	}
+	if p.InitialBytes < 0 || p.MaxEgress < 0 {
+		return errors.New("pacing settings must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// When the persistent uid cookie is set.
+const (
+	CookieModeAlways  = "always"
+	CookieModeConsent = "consent"
+	CookieModeNone    = "none"
+)
+
+// Privacy controls what is stored about listeners.
+//
+// CookieMode "consent" sets the uid cookie only if the ConsentCookie or
+// ConsentHeader has a true value ("1", "true" or "yes"); "none" never sets
+// it. With HonorDNT, DNT or Sec-GPC refuses the cookie in "always" and
+// "consent" mode. Without the cookie the uid only lives in the playlist URLs
+// of one session.
+//
+// IPv4Bits and IPv6Bits truncate client IPs to a prefix of that length
+// before they are stored or logged (0 keeps the full address). With
+// PseudonymizeUID uids are replaced by a keyed hash whose key rotates every
+// SaltRotation, so stored uids cannot be linked across periods. The key is
+// derived from the secret in SaltFile, to be shared by all instances, or
+// generated on startup if it is not set.
+type Privacy struct {
+	CookieMode      string   `json:"cookieMode"`
+	ConsentCookie   string   `json:"consentCookie"`
+	ConsentHeader   string   `json:"consentHeader"`
+	HonorDNT        bool     `json:"honorDNT"`
+	IPv4Bits        int      `json:"ipv4Bits"`
+	IPv6Bits        int      `json:"ipv6Bits"`
+	PseudonymizeUID bool     `json:"pseudonymizeUid"`
+	SaltFile        string   `json:"saltFile" reload:"restart"`
+	SaltRotation    Duration `json:"saltRotation" reload:"restart"`
+}
+
+func (p *Privacy) validate() error {
+	switch p.CookieMode {
+	case CookieModeAlways, CookieModeNone:
+	case CookieModeConsent:
+		if p.ConsentCookie == "" && p.ConsentHeader == "" {
+			return errors.New("cookie mode consent needs a consent cookie or header")
+		}
+	default:
+		return fmt.Errorf("invalid cookie mode %q", p.CookieMode)
+	}
+	if p.IPv4Bits < 0 || p.IPv4Bits > 32 || p.IPv6Bits < 0 || p.IPv6Bits > 128 {
+		return errors.New("IP prefix lengths must be 0-32 for IPv4 and 0-128 for IPv6")
+	}
+	// Checked even when uids are not pseudonymized, as a reload can turn that
+	// on while the rotation needs a restart.
+	if p.SaltRotation.Duration <= 0 {
+		return errors.New("salt rotation must be greater than 0")
+	}
+	return nil
+}
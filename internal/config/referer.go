@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Referer restricts which websites may embed the streams, by the host of the
+// Origin header, or the Referer header if there is no Origin. The rule of the
+// Streams entry with the longest matching path prefix applies, the top-level
+// rule otherwise.
+type Referer struct {
+	RefererRule
+	Streams []StreamReferer `json:"streams"`
+}
+
+// RefererRule is a referer allowlist. Hosts are exact names or wildcards
+// ("*.example.com" matches every subdomain). An empty Allow list allows all
+// referers. AllowEmpty allows requests without Origin and Referer, as sent by
+// apps. Disallowed playlist requests get the Fallback playlist file if set,
+// all other disallowed requests 403.
+type RefererRule struct {
+	Allow      List   `json:"allow"`
+	AllowEmpty bool   `json:"allowEmpty"`
+	Fallback   string `json:"fallback"`
+}
+
+// StreamReferer is the referer rule of the streams under a URL path prefix.
+type StreamReferer struct {
+	Path string `json:"path"`
+	RefererRule
+}
+
+// Rule returns the rule that applies to the URL path.
+func (r *Referer) Rule(path string) *RefererRule {
+	if i := longestPrefix(len(r.Streams), func(i int) string { return r.Streams[i].Path }, path); i >= 0 {
+		return &r.Streams[i].RefererRule
+	}
+	return &r.RefererRule
+}
+
+// Allows reports whether a request from host may be served. host is empty if
+// the request has neither Origin nor Referer.
+func (r *RefererRule) Allows(host string) bool {
+	if len(r.Allow) == 0 {
+		return true
+	}
+	if host == "" {
+		return r.AllowEmpty
+	}
+	host = strings.ToLower(host)
+	for _, pattern := range r.Allow {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Referer) validate() error {
+	if err := r.RefererRule.validate(); err != nil {
+		return err
+	}
+	for _, s := range r.Streams {
+		if !strings.HasPrefix(s.Path, "/") {
+			return fmt.Errorf("referer stream path %q must start with /", s.Path)
+		}
+		if err := s.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RefererRule) validate() error {
+	for _, pattern := range r.Allow {
+		if pattern == "" || strings.Contains(pattern[1:], "*") || (pattern[0] == '*' && !strings.HasPrefix(pattern, "*.")) {
+			return fmt.Errorf("invalid referer host %q", pattern)
+		}
+	}
+	if r.Fallback != "" && len(r.Allow) == 0 {
+		return errors.New("a referer fallback needs an allow list")
+	}
+	return nil
+}
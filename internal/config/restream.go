@@ -0,0 +1,33 @@
+package config
+
+import "errors"
+
+// Restream configures the detection of relayed sessions and shared
+// credentials. A sid or uid is flagged when, within Window, it is seen from
+// more than MaxIPs client IPs or MaxASNs networks, when a sid fetches more
+// than MaxSpeed seconds of media per second (after a minute of buffering),
+// or when a uid changes country within GeoWindow. Zero disables a check.
+// Flagged sids and uids are blocked for BlockFor if it is greater than 0.
+type Restream struct {
+	Window    Duration `json:"window"`
+	MaxIPs    int      `json:"maxIPs"`
+	MaxASNs   int      `json:"maxASNs"`
+	MaxSpeed  float64  `json:"maxSpeed"`
+	GeoWindow Duration `json:"geoWindow"`
+	BlockFor  Duration `json:"blockFor"`
+}
+
+// Enabled reports whether any check is enabled.
+func (r *Restream) Enabled() bool {
+	return r.MaxIPs > 0 || r.MaxASNs > 0 || r.MaxSpeed > 0 || r.GeoWindow.Duration > 0
+}
+
+func (r *Restream) validate() error {
+	if r.MaxIPs < 0 || r.MaxASNs < 0 || r.MaxSpeed < 0 || r.GeoWindow.Duration < 0 || r.BlockFor.Duration < 0 {
+		return errors.New("restream detection settings must not be negative")
+	}
+	if r.Enabled() && r.Window.Duration <= 0 {
+		return errors.New("restream detection window must be greater than 0")
+	}
+	return nil
+}
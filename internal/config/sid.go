@@ -0,0 +1,20 @@
+package config
+
+import "errors"
+
+// SIDSigning makes hserv issue session ids signed with a key, so clients
+// cannot forge them or use them longer than MaxAge. The key is read from
+// KeyFile, to be shared by all instances, or generated on startup if it is
+// not set.
+type SIDSigning struct {
+	Enabled bool     `json:"enabled"`
+	KeyFile string   `json:"keyFile" reload:"restart"`
+	MaxAge  Duration `json:"maxAge"`
+}
+
+func (s *SIDSigning) validate() error {
+	if s.Enabled && s.MaxAge.Duration <= 0 {
+		return errors.New("signed sid max age must be greater than 0")
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Where hserv reads playlists and chunks from.
+const (
+	SourceDir     = "dir"
+	SourceMemory  = "memory"
+	SourceArchive = "archive"
+	SourceS3      = "s3"
+)
+
+// Source selects where playlists and chunks are read from: the RootDir
+// directory (dir), the RootDir directory loaded into memory at startup
+// (memory), an Archive of a recording (archive), or an S3-compatible bucket
+// (s3). Request paths are resolved within the source as they are within
+// RootDir.
+type Source struct {
+	Type    string   `json:"type"`
+	Archive string   `json:"archive"`
+	S3      S3Source `json:"s3"`
+}
+
+// S3Source is an S3-compatible bucket, addressed path-style. The credentials
+// are taken from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY; without them
+// requests are anonymous.
+type S3Source struct {
+	Endpoint string   `json:"endpoint"`
+	Bucket   string   `json:"bucket"`
+	Region   string   `json:"region"`
+	Timeout  Duration `json:"timeout"`
+}
+
+func (s *Source) validate(origin *Origin) error {
+	switch s.Type {
+	case SourceDir, SourceMemory:
+	case SourceArchive:
+		if s.Archive == "" {
+			return errors.New("archive source needs an archive file")
+		}
+	case SourceS3:
+		if s.S3.Endpoint == "" || s.S3.Bucket == "" {
+			return errors.New("s3 source needs an endpoint and a bucket")
+		}
+		if s.S3.Timeout.Duration <= 0 {
+			return errors.New("s3 timeout must be greater than 0")
+		}
+	default:
+		return fmt.Errorf("invalid source type %q", s.Type)
+	}
+	if origin.Enabled() && s.Type != SourceDir {
+		return errors.New("edge mode needs the dir source")
+	}
+	return nil
+}
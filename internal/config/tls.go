@@ -0,0 +1,117 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// TLS holds the TLS policy of the TLS listener.
+type TLS struct {
+	MinVersion   string `json:"minVersion"`
+	CipherSuites List   `json:"cipherSuites"`
+	Curves       List   `json:"curves"`
+	// SessionTicketKeys is a file with one base64 encoded 32 byte key per
+	// line. The first key encrypts new tickets, all keys decrypt, so instances
+	// sharing the file resume each other's sessions.
+	SessionTicketKeys string `json:"sessionTicketKeys"`
+	// ClientCA is a PEM bundle of CAs that issue client certificates.
+	ClientCA   string `json:"clientCA"`
+	ClientAuth string `json:"clientAuth"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519":         tls.X25519,
+	"P256":           tls.CurveP256,
+	"P384":           tls.CurveP384,
+	"P521":           tls.CurveP521,
+	"X25519MLKEM768": tls.X25519MLKEM768,
+}
+
+var tlsClientAuth = map[string]tls.ClientAuthType{
+	"none":    tls.NoClientCert,
+	"request": tls.RequestClientCert,
+	"verify":  tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+// Version returns the minimum TLS version.
+func (t *TLS) Version() (uint16, error) {
+	v, ok := tlsVersions[t.MinVersion]
+	if !ok {
+		return 0, fmt.Errorf("invalid TLS version %q", t.MinVersion)
+	}
+	return v, nil
+}
+
+// CipherSuiteIDs returns the IDs of the configured cipher suites, nil for the
+// Go defaults. Only TLS 1.0-1.2 suites are configurable.
+func (t *TLS) CipherSuiteIDs() ([]uint16, error) {
+	if len(t.CipherSuites) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	ids := make([]uint16, 0, len(t.CipherSuites))
+	for _, name := range t.CipherSuites {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// CurveIDs returns the configured curve preferences, nil for the Go defaults.
+func (t *TLS) CurveIDs() ([]tls.CurveID, error) {
+	if len(t.Curves) == 0 {
+		return nil, nil
+	}
+	ids := make([]tls.CurveID, 0, len(t.Curves))
+	for _, name := range t.Curves {
+		id, ok := tlsCurves[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ClientAuthType returns the client certificate policy.
+func (t *TLS) ClientAuthType() (tls.ClientAuthType, error) {
+	a, ok := tlsClientAuth[t.ClientAuth]
+	if !ok {
+		return 0, fmt.Errorf("invalid client auth %q", t.ClientAuth)
+	}
+	return a, nil
+}
+
+func (t *TLS) validate() error {
+	if _, err := t.Version(); err != nil {
+		return err
+	}
+	if _, err := t.CipherSuiteIDs(); err != nil {
+		return err
+	}
+	if _, err := t.CurveIDs(); err != nil {
+		return err
+	}
+	a, err := t.ClientAuthType()
+	if err != nil {
+		return err
+	}
+	if a >= tls.VerifyClientCertIfGiven && t.ClientCA == "" {
+		return fmt.Errorf("client auth %q needs a client CA bundle", t.ClientAuth)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package content
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// OpenArchive opens a recording packed as a zip file, read in place, or as a
+// tar file, optionally gzipped, which is loaded into memory. The kind is
+// taken from the file extension.
+func OpenArchive(name string) (fs.FS, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		zr, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+		return zr, nil
+	case strings.HasSuffix(lower, ".tar"), strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return loadTar(name, !strings.HasSuffix(lower, ".tar"))
+	default:
+		return nil, fmt.Errorf("unknown archive type of %s, want .zip, .tar, .tar.gz or .tgz", name)
+	}
+}
+
+func loadTar(name string, gzipped bool) (*MemFS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive %s: %w", name, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	m := NewMemFS()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive %s: %w", name, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		file := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !fs.ValidPath(file) {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive %s: %w", name, err)
+		}
+		m.Add(file, data, hdr.ModTime)
+	}
+}
@@ -0,0 +1,124 @@
+// Package content provides the file systems hserv serves playlists and
+// chunks from: a local directory, memory, an archive or an S3-compatible
+// bucket. All of them are fs.FS and safe for concurrent use.
+package content
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MemFS is a read-only file system held in memory. Directories are implied by
+// the file names.
+type MemFS struct {
+	files map[string]*memFile
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memFile)}
+}
+
+// Add stores data as the file name. It must not be called once the file
+// system is in use.
+func (m *MemFS) Add(name string, data []byte, modTime time.Time) {
+	m.files[name] = &memFile{data: data, modTime: modTime}
+}
+
+// LoadDir reads all files below root into memory.
+func LoadDir(root string) (*MemFS, error) {
+	m := NewMemFS()
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		m.Add(filepath.ToSlash(rel), data, info.ModTime())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Open implements fs.FS.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if f := m.files[name]; f != nil {
+		return &openMemFile{Reader: bytes.NewReader(f.data), info: fileInfo{
+			name:    path.Base(name),
+			size:    int64(len(f.data)),
+			modTime: f.modTime,
+		}}, nil
+	}
+	if m.isDir(name) {
+		return &openMemFile{Reader: bytes.NewReader(nil), info: fileInfo{name: path.Base(name), dir: true}}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) isDir(name string) bool {
+	if name == "." {
+		return true
+	}
+	for n := range m.files {
+		if strings.HasPrefix(n, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type openMemFile struct {
+	*bytes.Reader
+	info fileInfo
+}
+
+func (f *openMemFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *openMemFile) Close() error               { return nil }
+
+// fileInfo is the fs.FileInfo of files that don't come from the local file
+// system.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return i.dir }
+func (i fileInfo) Sys() any           { return nil }
+
+func (i fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
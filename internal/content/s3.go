@@ -0,0 +1,178 @@
+package content
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// emptySHA256 is the hash of an empty request body.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Bucket is a read-only file system over an S3-compatible bucket, addressed
+// path-style as Endpoint/Bucket/name. Requests are signed with AWS
+// Signature Version 4 if AccessKey is set and anonymous otherwise.
+// SessionToken is sent with temporary credentials, e.g. of an IAM role.
+type Bucket struct {
+	Endpoint     string
+	Bucket       string
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+}
+
+// Open implements fs.FS. The file streams the object body.
+func (b *Bucket) Open(name string) (fs.File, error) {
+	resp, err := b.do(http.MethodGet, "open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &bucketFile{ReadCloser: resp.Body, info: objectInfo(name, resp)}, nil
+}
+
+// Stat implements fs.StatFS with a HEAD request.
+func (b *Bucket) Stat(name string) (fs.FileInfo, error) {
+	resp, err := b.do(http.MethodHead, "stat", name)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return objectInfo(name, resp), nil
+}
+
+func (b *Bucket) do(method, op, name string) (*http.Response, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	u, err := url.Parse(strings.TrimSuffix(b.Endpoint, "/"))
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	u.Path += "/" + b.Bucket + "/" + name
+	// Send the path encoded exactly as it is signed; the default encoding
+	// keeps characters like + and : that the signature escapes.
+	u.RawPath = uriEncodePath(u.Path)
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if b.AccessKey != "" {
+		b.sign(req, time.Now().UTC())
+	}
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		err = fs.ErrNotExist
+	case http.StatusForbidden:
+		err = fs.ErrPermission
+	default:
+		err = fmt.Errorf("bucket answered %s", resp.Status)
+	}
+	resp.Body.Close()
+	return nil, &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// sign adds an AWS Signature Version 4 authorization to a request without
+// body and query. The host, a range and every x-amz header are signed.
+func (b *Bucket) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if b.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"", // no query
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptySHA256,
+	}, "\n")
+	scope := date + "/" + b.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.SecretKey), date)
+	key = hmacSHA256(key, b.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+b.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncodePath encodes every byte of p but unreserved characters and
+// slashes, as Signature Version 4 expects.
+func uriEncodePath(p string) string {
+	var sb strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' || c == '/' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func objectInfo(name string, resp *http.Response) fileInfo {
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return fileInfo{name: path.Base(name), size: resp.ContentLength, modTime: modTime}
+}
+
+type bucketFile struct {
+	io.ReadCloser
+	info fileInfo
+}
+
+func (f *bucketFile) Stat() (fs.FileInfo, error) { return f.info, nil }
@@ -0,0 +1,279 @@
+// Package geoip resolves IP addresses to countries, cities and networks from
+// local database files.
+package geoip
+
+import (
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// DB is a read-only IP database loaded into memory: a MaxMind DB file (.mmdb)
+// or a CSV file of IP ranges. It is safe for concurrent use.
+type DB struct {
+	path    string
+	modTime time.Time
+	mmdb    *maxminddb.Reader
+	ranges  []ipRange
+}
+
+type ipRange struct {
+	start, end netip.Addr
+	loc        Location
+}
+
+// Location is what a database knows about an IP address. Fields missing from
+// the database are left empty.
+type Location struct {
+	Country string // ISO 3166-1 alpha-2 code
+	Region  string
+	City    string
+	ASN     uint32
+	ISP     string
+}
+
+// Open loads the database file at path. CSV files have one range per line,
+// "first_ip,last_ip" or "cidr" followed by the country and optionally the
+// region, city, ASN and ISP; a header line is skipped.
+func Open(path string) (*DB, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{path: path, modTime: info.ModTime()}
+	if strings.EqualFold(filepath.Ext(path), ".mmdb") {
+		// Read into memory instead of mmap, so a replaced reader can be left
+		// to the garbage collector while requests still use it.
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if db.mmdb, err = maxminddb.FromBytes(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return db, nil
+	}
+	if db.ranges, err = readRanges(path); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Path returns the file the database was loaded from.
+func (db *DB) Path() string {
+	return db.path
+}
+
+// Changed reports whether the file was modified or replaced since it was
+// loaded.
+func (db *DB) Changed() bool {
+	info, err := os.Stat(db.path)
+	return err != nil || !info.ModTime().Equal(db.modTime)
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country of ip, or an
+// empty string if it is unknown.
+func (db *DB) Country(ip netip.Addr) string {
+	ip = ip.Unmap()
+	if db.mmdb != nil {
+		var rec struct {
+			Country struct {
+				ISOCode string `maxminddb:"iso_code"`
+			} `maxminddb:"country"`
+			RegisteredCountry struct {
+				ISOCode string `maxminddb:"iso_code"`
+			} `maxminddb:"registered_country"`
+		}
+		if err := db.mmdb.Lookup(net.IP(ip.AsSlice()), &rec); err != nil {
+			return ""
+		}
+		if rec.Country.ISOCode != "" {
+			return rec.Country.ISOCode
+		}
+		return rec.RegisteredCountry.ISOCode
+	}
+	if rng := db.find(ip); rng != nil {
+		return rng.loc.Country
+	}
+	return ""
+}
+
+// Lookup returns everything the database knows about ip. MaxMind City,
+// Country, ASN and ISP databases are supported; English names are used.
+func (db *DB) Lookup(ip netip.Addr) Location {
+	ip = ip.Unmap()
+	if db.mmdb == nil {
+		if rng := db.find(ip); rng != nil {
+			return rng.loc
+		}
+		return Location{}
+	}
+
+	type names struct {
+		Names map[string]string `maxminddb:"names"`
+	}
+	var rec struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		RegisteredCountry struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"registered_country"`
+		Subdivisions []names `maxminddb:"subdivisions"`
+		City         names   `maxminddb:"city"`
+		ASN          uint32  `maxminddb:"autonomous_system_number"`
+		ASOrg        string  `maxminddb:"autonomous_system_organization"`
+		ISP          string  `maxminddb:"isp"`
+	}
+	if err := db.mmdb.Lookup(net.IP(ip.AsSlice()), &rec); err != nil {
+		return Location{}
+	}
+	loc := Location{
+		Country: cmp.Or(rec.Country.ISOCode, rec.RegisteredCountry.ISOCode),
+		City:    rec.City.Names["en"],
+		ASN:     rec.ASN,
+		ISP:     cmp.Or(rec.ISP, rec.ASOrg),
+	}
+	if len(rec.Subdivisions) > 0 {
+		loc.Region = rec.Subdivisions[0].Names["en"]
+	}
+	return loc
+}
+
+// find returns the CSV range containing ip, or nil.
+func (db *DB) find(ip netip.Addr) *ipRange {
+	i, found := slices.BinarySearchFunc(db.ranges, ip, func(r ipRange, ip netip.Addr) int {
+		return r.start.Compare(ip)
+	})
+	if !found {
+		i--
+	}
+	if i >= 0 && db.ranges[i].start.BitLen() == ip.BitLen() && db.ranges[i].end.Compare(ip) >= 0 {
+		return &db.ranges[i]
+	}
+	return nil
+}
+
+func readRanges(path string) ([]ipRange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	var ranges []ipRange
+	for line := 1; ; line++ {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		rng, err := parseRange(rec)
+		if err != nil {
+			if line == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		ranges = append(ranges, rng)
+	}
+	slices.SortFunc(ranges, func(a, b ipRange) int {
+		return cmp.Or(a.start.Compare(b.start), a.end.Compare(b.end))
+	})
+	return ranges, nil
+}
+
+func parseRange(rec []string) (ipRange, error) {
+	var (
+		rng    ipRange
+		fields []string
+		err    error
+	)
+	if len(rec) < 2 {
+		return rng, fmt.Errorf("expected at least 2 fields, got %d", len(rec))
+	}
+	if strings.Contains(rec[0], "/") {
+		var p netip.Prefix
+		if p, err = netip.ParsePrefix(strings.TrimSpace(rec[0])); err != nil {
+			return rng, err
+		}
+		p = p.Masked()
+		rng.start = p.Addr().Unmap()
+		rng.end = lastAddr(p)
+		fields = rec[1:]
+	} else {
+		if rng.start, err = netip.ParseAddr(strings.TrimSpace(rec[0])); err != nil {
+			return rng, err
+		}
+		if rng.end, err = netip.ParseAddr(strings.TrimSpace(rec[1])); err != nil {
+			return rng, err
+		}
+		rng.start, rng.end = rng.start.Unmap(), rng.end.Unmap()
+		if rng.start.BitLen() != rng.end.BitLen() || rng.end.Less(rng.start) {
+			return rng, errors.New("invalid range")
+		}
+		fields = rec[2:]
+	}
+	if len(fields) == 0 || len(fields) > 5 {
+		return rng, fmt.Errorf("expected 1 to 5 fields after the range, got %d", len(fields))
+	}
+	field := func(i int) string {
+		if i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+	rng.loc = Location{
+		Country: strings.ToUpper(field(0)),
+		Region:  field(1),
+		City:    field(2),
+		ISP:     field(4),
+	}
+	if asn := strings.TrimPrefix(strings.ToUpper(field(3)), "AS"); asn != "" {
+		n, err := strconv.ParseUint(asn, 10, 32)
+		if err != nil {
+			return rng, fmt.Errorf("invalid ASN %q", field(3))
+		}
+		rng.loc.ASN = uint32(n)
+	}
+	return rng, nil
+}
+
+// lastAddr returns the last address of the masked prefix p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().Unmap().AsSlice()
+	bits := p.Bits()
+	if p.Addr().Is4In6() {
+		bits -= 96
+	}
+	for i := range b {
+		switch {
+		case bits >= 8:
+			bits -= 8
+		case bits > 0:
+			b[i] |= 0xff >> bits
+			bits = 0
+		default:
+			b[i] = 0xff
+		}
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
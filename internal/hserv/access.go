@@ -0,0 +1,137 @@
+package hserv
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/uamana/hserv/internal/config"
+	"github.com/uamana/hserv/internal/geoip"
+)
+
+var accessDenied = expvar.NewMap("access_denied")
+
+// accessRules are the parsed access rules of a config.
+type accessRules struct {
+	allow, deny    []netip.Prefix
+	allowCountries map[string]bool
+	denyCountries  map[string]bool
+	countryDB      *geoip.DB
+	// asnDB resolves networks for restream detection.
+	asnDB *geoip.DB
+}
+
+// newAccessRules parses cfg and loads the country and ASN databases. The
+// databases of prev are reused if they are the same, unchanged files.
+func newAccessRules(cfg *config.Access, asnPath string, prev *accessRules) (*accessRules, error) {
+	allow, deny, err := cfg.Prefixes()
+	if err != nil {
+		return nil, err
+	}
+	rules := &accessRules{
+		allow:          allow,
+		deny:           deny,
+		allowCountries: countrySet(cfg.AllowCountries),
+		denyCountries:  countrySet(cfg.DenyCountries),
+	}
+	var prevCountry, prevASN *geoip.DB
+	if prev != nil {
+		prevCountry, prevASN = prev.countryDB, prev.asnDB
+	}
+	if rules.countryDB, err = openDB(cfg.CountryDB, prevCountry); err != nil {
+		return nil, err
+	}
+	if rules.asnDB, err = openDB(asnPath, prevASN); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// openDB opens the database at path, or returns prev if it is the same,
+// unchanged file. It returns nil if path is empty.
+func openDB(path string, prev *geoip.DB) (*geoip.DB, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if prev != nil && prev.Path() == path && !prev.Changed() {
+		return prev, nil
+	}
+	db, err := geoip.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("loaded IP database", "path", path)
+	return db, nil
+}
+
+// asn returns the network of ip, or 0 if there is no database or it is
+// unknown.
+func (a *accessRules) asn(ip netip.Addr) uint32 {
+	if a.asnDB == nil || !ip.IsValid() {
+		return 0
+	}
+	return a.asnDB.Lookup(ip).ASN
+}
+
+func countrySet(list config.List) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, c := range list {
+		set[strings.ToUpper(c)] = true
+	}
+	return set
+}
+
+// country returns the country code of ip, or an empty string if there is no
+// database or the country is unknown.
+func (a *accessRules) country(ip netip.Addr) string {
+	if a.countryDB == nil || !ip.IsValid() {
+		return ""
+	}
+	return a.countryDB.Country(ip)
+}
+
+// check applies the rules documented on config.Access. It returns the reason
+// for a denial, or an empty string if the client is allowed.
+func (a *accessRules) check(ip netip.Addr, country string) string {
+	contains := func(prefixes []netip.Prefix) bool {
+		return slices.ContainsFunc(prefixes, func(p netip.Prefix) bool { return p.Contains(ip) })
+	}
+	switch {
+	case contains(a.deny):
+		return "ip"
+	case contains(a.allow):
+		return ""
+	case a.denyCountries[country]:
+		return "country"
+	case a.allowCountries[country]:
+		return ""
+	case len(a.allow) > 0 || len(a.allowCountries) > 0:
+		return "not_allowed"
+	}
+	return ""
+}
+
+// checkAccess answers 403 if the access rules deny the client. It reports
+// whether the request may be served.
+func (h *HServ) checkAccess(w http.ResponseWriter, r *http.Request, rules *accessRules, ip netip.Addr, country string) bool {
+	reason := rules.check(ip, country)
+	if reason == "" {
+		return true
+	}
+	accessDenied.Add(reason, 1)
+	slog.Info("access denied", "reason", reason, "ip", logIP(r, h.Config()), "country", country, "path", r.URL.Path)
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	return false
+}
+
+// clientAddr returns the IP address of the client.
+func clientAddr(r *http.Request) netip.Addr {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
@@ -0,0 +1,123 @@
+package hserv
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+// acmeSource obtains and renews certificates for the configured domains from
+// an ACME CA. It answers TLS-ALPN-01 challenges in the TLS handshake and
+// HTTP-01 challenges through httpHandler.
+type acmeSource struct {
+	m       *autocert.Manager
+	domains map[string]bool
+}
+
+func newACMESource(cfg *config.ACME) (*acmeSource, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CABundle != "" {
+		pem, err := os.ReadFile(cfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ACME CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in ACME CA bundle")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	client := &acme.Client{
+		DirectoryURL: cfg.DirectoryURL,
+		HTTPClient:   &http.Client{Transport: &orderLocator{next: transport}},
+	}
+
+	domains := make(map[string]bool, len(cfg.Domains))
+	for _, d := range cfg.Domains {
+		domains[strings.ToLower(d)] = true
+	}
+	return &acmeSource{
+		m: &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cfg.CacheDir),
+			HostPolicy: autocert.HostWhitelist(cfg.Domains...),
+			Email:      cfg.Email,
+			Client:     client,
+		},
+		domains: domains,
+	}, nil
+}
+
+// orderLocator adds the order URL to finalize responses without a Location
+// header. RFC 8555 doesn't require one, but the acme package polls a
+// processing order at it, and CAs that finalize asynchronously, like Pebble,
+// leave it out. The order URL is remembered from the new order response.
+type orderLocator struct {
+	next   http.RoundTripper
+	mu     sync.Mutex
+	orders map[string]string // finalize URL -> order URL
+}
+
+func (o *orderLocator) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := o.next.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPost || resp.StatusCode >= http.StatusMultipleChoices {
+		return resp, err
+	}
+	if loc := resp.Header.Get("Location"); loc != "" {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		var order struct {
+			Finalize string `json:"finalize"`
+		}
+		if json.Unmarshal(body, &order) == nil && order.Finalize != "" {
+			o.mu.Lock()
+			if o.orders == nil {
+				o.orders = make(map[string]string)
+			}
+			o.orders[order.Finalize] = loc
+			o.mu.Unlock()
+		}
+		return resp, nil
+	}
+	o.mu.Lock()
+	loc, ok := o.orders[req.URL.String()]
+	delete(o.orders, req.URL.String())
+	o.mu.Unlock()
+	if ok {
+		resp.Header.Set("Location", loc)
+	}
+	return resp, nil
+}
+
+// handles reports whether the handshake is for an ACME domain or is an
+// ACME TLS-ALPN-01 challenge.
+func (a *acmeSource) handles(hello *tls.ClientHelloInfo) bool {
+	if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+		return true
+	}
+	return a.domains[strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")]
+}
+
+// httpHandler answers HTTP-01 challenges and passes other requests to
+// fallback (or redirects them to HTTPS if fallback is nil).
+func (a *acmeSource) httpHandler(fallback http.Handler) http.Handler {
+	return a.m.HTTPHandler(fallback)
+}
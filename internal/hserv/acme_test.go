@@ -0,0 +1,93 @@
+package hserv
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// TestACMEPebble obtains a certificate from an ACME test CA such as Pebble.
+// It is skipped unless HSERV_TEST_ACME_DIR is the directory URL. The CA must
+// validate the domain (HSERV_TEST_ACME_DOMAIN, default hserv.test) against
+// this host on the TLS and HTTP addresses (HSERV_TEST_ACME_TLSADDR and
+// HSERV_TEST_ACME_HTTPADDR, default :5001 and :5002, Pebble's tlsPort and
+// httpPort); with Pebble, resolve the domain with pebble-challtestsrv:
+//
+//	pebble-challtestsrv -defaultIPv4 127.0.0.1 &
+//	pebble -config test/config/pebble-config.json -dnsserver 127.0.0.1:8053 &
+//	HSERV_TEST_ACME_DIR=https://localhost:14000/dir \
+//	HSERV_TEST_ACME_CA=test/certs/pebble.minica.pem go test -run ACME ./internal/hserv
+func TestACMEPebble(t *testing.T) {
+	dir := os.Getenv("HSERV_TEST_ACME_DIR")
+	if dir == "" {
+		t.Skip("HSERV_TEST_ACME_DIR not set")
+	}
+	domain := envOr("HSERV_TEST_ACME_DOMAIN", "hserv.test")
+
+	cfg := config.Default()
+	cfg.Addr = envOr("HSERV_TEST_ACME_TLSADDR", ":5001")
+	cfg.HTTPAddr = envOr("HSERV_TEST_ACME_HTTPADDR", ":5002")
+	cfg.ACME = config.ACME{
+		Domains:      config.List{domain},
+		DirectoryURL: dir,
+		CABundle:     os.Getenv("HSERV_TEST_ACME_CA"),
+		CacheDir:     t.TempDir(),
+	}
+	h := New(cfg)
+	h.Content = testStream
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- h.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+
+	_, port, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := net.JoinHostPort("127.0.0.1", port)
+	for !h.ready.Load() {
+		select {
+		case err := <-done:
+			t.Fatalf("hserv stopped: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// The first handshake for the domain orders the certificate; the CA
+	// validates it with challenges answered by the same hserv.
+	dialer := &net.Dialer{Timeout: 2 * time.Minute}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+		ServerName: domain,
+		// The test CA's root is generated on its start.
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	leaf := conn.ConnectionState().PeerCertificates[0]
+	if err := leaf.VerifyHostname(domain); err != nil {
+		t.Error(err)
+	}
+	if leaf.Issuer.String() == leaf.Subject.String() {
+		t.Errorf("got a self-signed certificate from %s", leaf.Issuer)
+	}
+}
@@ -0,0 +1,62 @@
+package hserv
+
+import (
+	"encoding/json"
+	"expvar"
+	"log/slog"
+	"net/http"
+)
+
+type reloadResponse struct {
+	Error           string   `json:"error,omitempty"`
+	RestartRequired []string `json:"restartRequired"`
+}
+
+// adminHandler serves the admin endpoint: POST /reload reloads the config,
+// /debug/vars exposes expvar metrics, /restream/alerts lists recent restream
+// alerts, /healthz and /readyz are probes.
+func (h *HServ) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /reload", h.reloadHandler)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("GET /restream/alerts", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(h.restream.recentAlerts()); err != nil {
+			slog.Error("failed to write restream alerts", "error", err)
+		}
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /readyz", h.readyHandler)
+	return mux
+}
+
+// readyHandler reports 503 once shutdown has begun, so load balancers stop
+// sending new listeners while in-flight requests drain.
+func (h *HServ) readyHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.ready.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+func (h *HServ) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("admin request, reloading configuration", "ip", r.RemoteAddr)
+	resp := reloadResponse{RestartRequired: []string{}}
+	status := http.StatusOK
+	restart, err := h.Reload()
+	if err != nil {
+		slog.Error("keeping old configuration because the new one could not be loaded", "error", err)
+		resp.Error = err.Error()
+		status = http.StatusUnprocessableEntity
+	} else if restart != nil {
+		resp.RestartRequired = restart
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("failed to write reload response", "error", err)
+	}
+}
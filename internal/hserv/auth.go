@@ -0,0 +1,341 @@
+package hserv
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/uamana/hserv/internal/config"
+)
+
+var authFailures = expvar.NewMap("auth_failures")
+
+// jwtVerifier verifies listener tokens with the keys of a config.
+type jwtVerifier struct {
+	parser  *jwt.Parser
+	hmacKey []byte
+	keys    []crypto.PublicKey
+	kids    map[string]crypto.PublicKey
+}
+
+// newJWTVerifier loads the keys of cfg. It returns nil if authentication is
+// not enabled.
+func newJWTVerifier(cfg *config.Auth) (*jwtVerifier, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(cfg.Algorithms),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if !cfg.AllowNoExpiry {
+		opts = append(opts, jwt.WithExpirationRequired())
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v := &jwtVerifier{parser: jwt.NewParser(opts...), kids: make(map[string]crypto.PublicKey)}
+
+	var err error
+	if cfg.HMACKeyFile != "" {
+		if v.hmacKey, err = readSecret(cfg.HMACKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range cfg.PublicKeyFiles {
+		keys, err := readPublicKeys(path)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, keys...)
+	}
+	if cfg.JWKSFile != "" {
+		if err := v.readJWKS(cfg.JWKSFile); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// verify returns the claims of a valid token.
+func (v *jwtVerifier) verify(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.key); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *jwtVerifier) key(t *jwt.Token) (any, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+		if v.hmacKey == nil {
+			return nil, errors.New("no HMAC key")
+		}
+		return v.hmacKey, nil
+	}
+	if kid, _ := t.Header["kid"].(string); kid != "" {
+		if key, ok := v.kids[kid]; ok {
+			return key, nil
+		}
+	}
+	if len(v.keys) == 0 {
+		return nil, errors.New("no public key")
+	}
+	keys := make([]jwt.VerificationKey, len(v.keys))
+	for i, k := range v.keys {
+		keys[i] = k
+	}
+	return jwt.VerificationKeySet{Keys: keys}, nil
+}
+
+// readPublicKeys reads the PEM public keys and certificates of a file.
+func readPublicKeys(path string) ([]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		var key any
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no public keys found", path)
+	}
+	return keys, nil
+}
+
+// readJWKS reads the RSA and P-256 keys of a JWKS file.
+func (v *jwtVerifier) readJWKS(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	b64 := base64.RawURLEncoding.DecodeString
+	for _, k := range set.Keys {
+		var key crypto.PublicKey
+		switch {
+		case k.Kty == "RSA":
+			n, errN := b64(k.N)
+			e, errE := b64(k.E)
+			if err := errors.Join(errN, errE); err != nil {
+				return fmt.Errorf("%s: key %q: %w", path, k.Kid, err)
+			}
+			key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, errX := b64(k.X)
+			y, errY := b64(k.Y)
+			if err := errors.Join(errX, errY); err != nil {
+				return fmt.Errorf("%s: key %q: %w", path, k.Kid, err)
+			}
+			if key, err = ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...)); err != nil {
+				return fmt.Errorf("%s: key %q: %w", path, k.Kid, err)
+			}
+		default:
+			continue
+		}
+		v.keys = append(v.keys, key)
+		if k.Kid != "" {
+			v.kids[k.Kid] = key
+		}
+	}
+	if len(v.keys) == 0 {
+		return fmt.Errorf("%s: no supported keys found", path)
+	}
+	return nil
+}
+
+// listener is an authenticated listener.
+type listener struct {
+	subject string
+	claims  map[string]string
+	all     jwt.MapClaims
+	// token is set if it came in the query and has to be added to the URLs
+	// of rewritten playlists.
+	token string
+}
+
+// tokenFromRequest returns the token of the request and whether it came in
+// the query.
+func tokenFromRequest(r *http.Request, cfg *config.Auth) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token), false
+	}
+	if cfg.Cookie != "" {
+		if c, err := r.Cookie(cfg.Cookie); err == nil {
+			return c.Value, false
+		}
+	}
+	if cfg.QueryParam != "" {
+		if token := r.URL.Query().Get(cfg.QueryParam); token != "" {
+			return token, true
+		}
+	}
+	return "", false
+}
+
+// authenticate verifies the token of the request. It answers 401 to a
+// request with an invalid token, or a playlist request without one if
+// authentication is required, and reports whether the request may be
+// served. The listener is nil for anonymous requests.
+func (h *HServ) authenticate(w http.ResponseWriter, r *http.Request, cfg *config.Config, isPlaylist bool) (*listener, bool) {
+	v := h.auth.Load()
+	if v == nil {
+		return nil, true
+	}
+	token, inQuery := tokenFromRequest(r, &cfg.Auth)
+	if token == "" {
+		if cfg.Auth.Required && isPlaylist {
+			authFailures.Add("missing", 1)
+			unauthorized(w, "")
+			return nil, false
+		}
+		return nil, true
+	}
+	claims, err := v.verify(token)
+	if err != nil {
+		authFailures.Add("invalid", 1)
+		slog.Info("invalid listener token", "error", err, "ip", logIP(r, cfg), "path", r.URL.Path)
+		unauthorized(w, "invalid_token")
+		return nil, false
+	}
+
+	l := &listener{all: claims, claims: make(map[string]string)}
+	if sub, _ := claims.GetSubject(); sub != "" {
+		l.subject = SubjectUID(sub)
+	}
+	for _, name := range cfg.Auth.Claims {
+		if value, ok := claims[name]; ok {
+			l.claims[name] = claimString(value)
+		}
+	}
+	if inQuery {
+		l.token = token
+	}
+	return l, true
+}
+
+// subjectNamespace is the namespace of uids derived from token subjects.
+var subjectNamespace = uuid.MustParse("8f0c6a8e-3f4b-5d2a-9c71-2b6f1e4d7a90")
+
+// SubjectUID returns the uid of a token subject: the subject itself if it is
+// a UUID, a name-based UUID of it otherwise, as uids are stored as UUIDs.
+func SubjectUID(sub string) string {
+	if _, err := uuid.Parse(sub); err == nil {
+		return sub
+	}
+	return uuid.NewSHA1(subjectNamespace, []byte(sub)).String()
+}
+
+func unauthorized(w http.ResponseWriter, errCode string) {
+	challenge := "Bearer"
+	if errCode != "" {
+		challenge += ` error="` + errCode + `"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func claimString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []any:
+		s := make([]string, len(v))
+		for i, e := range v {
+			s[i] = claimString(e)
+		}
+		return strings.Join(s, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// checkClaims applies the claim rules of the request path. It answers 403
+// if the listener does not have the required claims and reports whether the
+// request may be served.
+func (h *HServ) checkClaims(w http.ResponseWriter, r *http.Request, cfg *config.Config, l *listener) bool {
+	for _, rule := range cfg.Access.Claims {
+		if !strings.HasPrefix(r.URL.Path, rule.Path) {
+			continue
+		}
+		for name, allowed := range rule.Claims {
+			var values []string
+			if l != nil {
+				if value, ok := l.all[name]; ok {
+					values = strings.Split(claimString(value), ",")
+				}
+			}
+			if !containsAny(allowed, values) {
+				accessDenied.Add("claims", 1)
+				slog.Info("access denied", "reason", "claims", "claim", name, "ip", logIP(r, cfg), "path", r.URL.Path)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func containsAny(allowed config.List, values []string) bool {
+	for _, a := range allowed {
+		for _, v := range values {
+			if v == a {
+				return true
+			}
+		}
+	}
+	return false
+}
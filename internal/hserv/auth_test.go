@@ -0,0 +1,84 @@
+package hserv
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/uamana/hserv/internal/config"
+)
+
+// testJWTKey writes an HS256 key file and returns it with a function
+// signing tokens with the key.
+func testJWTKey(t *testing.T) (string, func(jwt.MapClaims) string) {
+	t.Helper()
+	key := []byte("0123456789abcdef0123456789abcdef")
+	keyFile := filepath.Join(t.TempDir(), "jwt.key")
+	if err := os.WriteFile(keyFile, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile, func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+}
+
+func TestJWTExpirationRequired(t *testing.T) {
+	keyFile, sign := testJWTKey(t)
+	withExp := sign(jwt.MapClaims{"sub": "listener", "exp": time.Now().Add(time.Hour).Unix()})
+	noExp := sign(jwt.MapClaims{"sub": "listener"})
+
+	for _, tc := range []struct {
+		allowNoExpiry bool
+		token         string
+		valid         bool
+	}{
+		{false, withExp, true},
+		{false, noExp, false},
+		{true, withExp, true},
+		{true, noExp, true},
+	} {
+		cfg := config.Default().Auth
+		cfg.HMACKeyFile = keyFile
+		cfg.AllowNoExpiry = tc.allowNoExpiry
+		v, err := newJWTVerifier(&cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = v.verify(tc.token)
+		if (err == nil) != tc.valid {
+			t.Errorf("allowNoExpiry %v, exp %v: error %v, want valid %v",
+				tc.allowNoExpiry, tc.token == withExp, err, tc.valid)
+		}
+	}
+}
+
+func TestJWTSubjectUID(t *testing.T) {
+	keyFile, sign := testJWTKey(t)
+	cfg := config.Default()
+	cfg.Auth.HMACKeyFile = keyFile
+	h := newTestHServ(t, cfg, testStream)
+	client := testClient{addr: "192.0.2.1:40000", userAgent: "player"}
+
+	for _, sub := range []string{"alice@example.com", "0b7e3e4c-5f5e-4d1a-9a43-2c4f0b6f1e21"} {
+		token := sign(jwt.MapClaims{"sub": sub, "exp": time.Now().Add(time.Hour).Unix()})
+		w := client.get(h, "/s.m3u8?token="+token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("playlist for %q: %d", sub, w.Code)
+		}
+		// The uid of the playlist is the uid chunk events are stored under.
+		uid := playlistQuery(t, w.Body.String()).Get("uid")
+		if uid != SubjectUID(sub) {
+			t.Errorf("uid of subject %q = %q, want %q", sub, uid, SubjectUID(sub))
+		}
+	}
+	if SubjectUID("alice@example.com") == SubjectUID("bob@example.com") {
+		t.Error("different subjects have the same uid")
+	}
+}
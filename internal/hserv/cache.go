@@ -0,0 +1,124 @@
+package hserv
+
+import (
+	"bytes"
+	"container/list"
+	"expvar"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+var chunkCacheStats = expvar.NewMap("chunk_cache")
+
+// chunkCache is an LRU of chunk files bounded by bytes. An entry is valid
+// while the file keeps its size and modification time. The size limit is
+// passed on every call, so it follows config reloads.
+type chunkCache struct {
+	mu      sync.Mutex
+	items   map[string]*list.Element // name -> *cachedChunk
+	lru     list.List                // most recently used first
+	size    int64
+	loading map[string]*chunkLoad
+}
+
+type cachedChunk struct {
+	name    string
+	modTime time.Time
+	data    []byte
+}
+
+// chunkLoad is a file being read into the cache; concurrent misses of the
+// same file wait for it instead of reading it again.
+type chunkLoad struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// openChunk returns the content of the chunk name, from memory if the cache
+// is enabled and the chunk is small enough, or else the open file.
+func (h *HServ) openChunk(name string, info fs.FileInfo, cfg *config.ChunkCache) (io.ReadCloser, error) {
+	max := cfg.Bytes()
+	if max <= 0 || info.Size() > max/16 {
+		return h.Content.Open(name)
+	}
+	data, err := h.chunks.get(h.Content, name, info, max)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// get returns the content of the file name in fsys, reading it on a miss.
+func (c *chunkCache) get(fsys fs.FS, name string, info fs.FileInfo, max int64) ([]byte, error) {
+	c.mu.Lock()
+	if c.items == nil {
+		c.items = make(map[string]*list.Element)
+		c.loading = make(map[string]*chunkLoad)
+	}
+	if e := c.items[name]; e != nil {
+		item := e.Value.(*cachedChunk)
+		if item.modTime.Equal(info.ModTime()) && int64(len(item.data)) == info.Size() {
+			c.lru.MoveToFront(e)
+			c.mu.Unlock()
+			chunkCacheStats.Add("hits", 1)
+			return item.data, nil
+		}
+		c.remove(e)
+	}
+	if load := c.loading[name]; load != nil {
+		c.mu.Unlock()
+		<-load.done
+		chunkCacheStats.Add("hits", 1)
+		return load.data, load.err
+	}
+	load := &chunkLoad{done: make(chan struct{})}
+	c.loading[name] = load
+	c.mu.Unlock()
+
+	chunkCacheStats.Add("misses", 1)
+	load.data, load.err = fs.ReadFile(fsys, name)
+	close(load.done)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.loading, name)
+	// Don't keep a file that changed while it was read.
+	if load.err == nil && int64(len(load.data)) == info.Size() {
+		c.items[name] = c.lru.PushFront(&cachedChunk{name: name, modTime: info.ModTime(), data: load.data})
+		c.size += info.Size()
+		chunkCacheStats.Add("bytes", info.Size())
+		c.trimLocked(max)
+	}
+	return load.data, load.err
+}
+
+// trim evicts the least recently used chunks until the cache fits in max
+// bytes.
+func (c *chunkCache) trim(max int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trimLocked(max)
+}
+
+func (c *chunkCache) trimLocked(max int64) {
+	for c.size > max {
+		e := c.lru.Back()
+		if e == nil {
+			return
+		}
+		c.remove(e)
+		chunkCacheStats.Add("evictions", 1)
+	}
+}
+
+func (c *chunkCache) remove(e *list.Element) {
+	item := c.lru.Remove(e).(*cachedChunk)
+	delete(c.items, item.name)
+	c.size -= int64(len(item.data))
+	chunkCacheStats.Add("bytes", -int64(len(item.data)))
+}
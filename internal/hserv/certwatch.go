@@ -0,0 +1,108 @@
+package hserv
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+// certExpirySeconds exposes the remaining lifetime of every loaded certificate,
+// keyed by certificate path.
+var certExpirySeconds = expvar.NewMap("tls_cert_expiry_seconds")
+
+// certExpiryWarnEvery limits how often an expiring certificate is logged.
+const certExpiryWarnEvery = time.Hour
+
+// watchTLS polls the certificate, key, client CA and session ticket key files
+// every interval and reloads them once a change has been stable for a full
+// interval, so a renewal that writes the cert and key separately is picked up
+// as a whole. It also updates the expiry metric and warns about certificates
+// close to expiry.
+func (h *HServ) watchTLS(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := tlsFilesState(h.Config())
+	pending := false
+	lastWarn := make(map[string]time.Time)
+	if h.kpr != nil {
+		h.kpr.checkExpiry(h.Config().CertExpiryWarnDays, lastWarn)
+	}
+	for {
+		select {
+		case <-ticker.C:
+			cfg := h.Config()
+			state := tlsFilesState(cfg)
+			switch {
+			case state != last:
+				last = state
+				pending = true
+			case pending:
+				pending = false
+				slog.Info("TLS files changed, reloading")
+				h.reloadTLS(cfg)
+			}
+			if h.kpr != nil {
+				h.kpr.checkExpiry(cfg.CertExpiryWarnDays, lastWarn)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tlsFilesState summarizes path, size and modification time of every
+// configured TLS file.
+func tlsFilesState(cfg *config.Config) string {
+	pairs, err := certPairs(cfg)
+	if err != nil {
+		return ""
+	}
+	paths := []string{cfg.TLS.ClientCA, cfg.TLS.SessionTicketKeys}
+	for _, p := range pairs {
+		paths = append(paths, p.Cert, p.Key)
+	}
+	var sb strings.Builder
+	for _, path := range paths {
+		sb.WriteString(path)
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&sb, ":%d:%d", info.Size(), info.ModTime().UnixNano())
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func (kpr *keypairReloader) checkExpiry(warnDays int, lastWarn map[string]time.Time) {
+	kpr.certMu.RLock()
+	defer kpr.certMu.RUnlock()
+
+	now := time.Now()
+	certExpirySeconds.Init()
+	for path, cert := range kpr.certs {
+		left := cert.Leaf.NotAfter.Sub(now)
+		v := new(expvar.Int)
+		v.Set(int64(left.Seconds()))
+		certExpirySeconds.Set(path, v)
+
+		if warnDays <= 0 || left > time.Duration(warnDays)*24*time.Hour {
+			delete(lastWarn, path)
+			continue
+		}
+		if now.Sub(lastWarn[path]) < certExpiryWarnEvery {
+			continue
+		}
+		lastWarn[path] = now
+		if left <= 0 {
+			slog.Error("TLS certificate has expired", "cert", path, "notAfter", cert.Leaf.NotAfter)
+		} else {
+			slog.Warn("TLS certificate expires soon", "cert", path, "notAfter", cert.Leaf.NotAfter, "daysLeft", int(left.Hours()/24))
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package hserv
+
+import (
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/uamana/hserv/internal/config"
+	"github.com/uamana/hserv/internal/content"
+)
+
+// newContentSource opens the file system playlists and chunks are read from.
+func newContentSource(cfg *config.Config) (fs.FS, error) {
+	switch cfg.Source.Type {
+	case config.SourceMemory:
+		m, err := content.LoadDir(cfg.RootDir)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("loaded root directory into memory", "path", cfg.RootDir)
+		return m, nil
+	case config.SourceArchive:
+		return content.OpenArchive(cfg.Source.Archive)
+	case config.SourceS3:
+		s3 := &cfg.Source.S3
+		return &content.Bucket{
+			Endpoint:     s3.Endpoint,
+			Bucket:       s3.Bucket,
+			Region:       s3.Region,
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			Client:       &http.Client{Timeout: s3.Timeout.Duration},
+		}, nil
+	default:
+		return os.DirFS(cfg.RootDir), nil
+	}
+}
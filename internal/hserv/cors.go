@@ -0,0 +1,63 @@
+package hserv
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+// setCORS adds the CORS headers of the policy for the request path. It
+// answers OPTIONS requests itself and reports whether the request is done.
+func setCORS(w http.ResponseWriter, r *http.Request, cfg *config.Config) bool {
+	policy := cfg.CORS.Policy(r.URL.Path)
+	origin := r.Header.Get("Origin")
+	h := w.Header()
+
+	if len(policy.AllowOrigins) > 0 {
+		anyOrigin := policy.AllowsAny() && !policy.AllowCredentials
+		if !anyOrigin {
+			h.Add("Vary", "Origin")
+		}
+		switch {
+		case anyOrigin:
+			h.Set("Access-Control-Allow-Origin", "*")
+		case origin != "" && policy.Allows(origin):
+			h.Set("Access-Control-Allow-Origin", origin)
+		default:
+			origin = ""
+		}
+		if origin != "" {
+			if policy.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if len(policy.ExposeHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposeHeaders, ", "))
+			}
+		}
+	}
+
+	if r.Method != http.MethodOptions {
+		return false
+	}
+	h.Set("Allow", "GET, HEAD, OPTIONS")
+	if origin != "" && r.Header.Get("Access-Control-Request-Method") != "" && len(policy.AllowOrigins) > 0 {
+		// Preflight request.
+		h.Set("Access-Control-Allow-Methods", "GET, HEAD")
+		if headers := strings.Join(policy.AllowHeaders, ", "); headers != "" {
+			if headers == "*" && policy.AllowCredentials {
+				// "*" is taken literally in credentialed requests.
+				headers = r.Header.Get("Access-Control-Request-Headers")
+			}
+			if headers != "" {
+				h.Set("Access-Control-Allow-Headers", headers)
+			}
+		}
+		if policy.MaxAge.Duration > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
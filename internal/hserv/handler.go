@@ -3,10 +3,13 @@ package hserv
 import (
 	"bufio"
 	"bytes"
-	"io"
+	"errors"
+	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
-	"os"
+	"net/url"
+	pathpkg "path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -17,30 +20,79 @@ import (
 )
 
 func (h *HServ) handler(w http.ResponseWriter, r *http.Request) {
+	cfg := h.Config()
+	setHSTS(w, r, &cfg.HSTS)
+	if setCORS(w, r, cfg) {
+		return
+	}
+
 	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		slog.Error("method not allowed", "method", r.Method)
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
 
-	path := filepath.Join(h.RootDir, filepath.FromSlash(r.URL.Path))
-	rel, relErr := filepath.Rel(h.RootDir, path)
-	if relErr != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+	// name is the file within the content source; path is the file as it
+	// would be in the root directory, for logging.
+	name := strings.TrimPrefix(pathpkg.Clean("/"+r.URL.Path), "/")
+	if !fs.ValidPath(name) {
 		slog.Error("wrong path", "path", r.URL.Path)
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
+	path := filepath.Join(cfg.RootDir, filepath.FromSlash(name))
 
 	fileExt := filepath.Ext(path)
-	if fileExt != h.ChunkExt && fileExt != ".m3u8" {
+	if fileExt != cfg.ChunkExt && fileExt != ".m3u8" {
 		slog.Error("wrong file extension", "extension", fileExt)
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
 
-	info, err := os.Stat(path)
+	user, ok := h.authenticate(w, r, cfg, fileExt == ".m3u8")
+	if !ok {
+		return
+	}
+	var authUID string
+	if user != nil {
+		authUID = user.subject
+	}
+
+	access, addr := h.access.Load(), clientAddr(r)
+	country := access.country(addr)
+	if !h.checkAccess(w, r, access, addr, country) {
+		return
+	}
+
+	if !h.checkClaims(w, r, cfg, user) {
+		return
+	}
+
+	if !h.checkReferer(w, r, cfg, fileExt == ".m3u8") {
+		return
+	}
+
+	if h.rateLimit(w, r, cfg, path, authUID, country, fileExt == ".m3u8") {
+		return
+	}
+
+	if h.origin != nil {
+		if err := h.origin.pull(&cfg.Origin, name, fileExt == ".m3u8"); err != nil {
+			if errors.Is(err, errOriginNotFound) {
+				slog.Error("file not found", "error", err, "path", name)
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			} else {
+				slog.Error("failed to pull file from origin", "error", err, "path", name)
+				http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			}
+			return
+		}
+	}
+
+	info, err := fs.Stat(h.Content, name)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
 			slog.Error("file not found", "error", err)
 			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		} else {
@@ -58,7 +110,7 @@ func (h *HServ) handler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodHead {
 		setHeaders(w)
 		if fileExt != ".m3u8" {
-			w.Header().Set("Content-Type", h.ChunkMIME)
+			w.Header().Set("Content-Type", cfg.ChunkMIME)
 			w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
 		} else {
 			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
@@ -68,89 +120,138 @@ func (h *HServ) handler(w http.ResponseWriter, r *http.Request) {
 
 	// get uid if possible (cookie set) and sid
 	var (
-		uid      string
-		isNewUid bool
-		sid      string = r.URL.Query().Get(h.SidName)
+		uid       string
+		isNewUid  bool
+		sid       string = r.URL.Query().Get(cfg.SidName)
+		sidToken  string
+		sidStatus string
 	)
 
 	if sid == "" {
 		sid = uuid.New().String()
 	}
+	sidToken = sid
 
-	uidCookie, err := r.Cookie(h.UidName)
-	if err != nil && err != http.ErrNoCookie {
-		slog.Error("failed to get uid cookie", "error", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
+	track := trackingAllowed(r, &cfg.Privacy)
+	if _, err := r.Cookie(cfg.UIDCookie.Name); err == nil && !track {
+		// Consent was withdrawn: forget the persistent uid.
+		http.SetCookie(w, newUIDCookie(cfg, "", -1))
 	}
-	if uidCookie == nil {
-		uid = r.URL.Query().Get(h.UidName)
-		if uid == "" {
-			uid = uuid.New().String()
-			isNewUid = true
+	id := resolveIdentity(r, cfg, authUID, track)
+	if len(id.merged) > 0 {
+		uidConflicts.Add(id.source, 1)
+		merged := make([]string, len(id.merged))
+		for i, uid := range id.merged {
+			merged[i] = h.logUID(uid, cfg)
 		}
-		uidCookie = &http.Cookie{
-			Name:     h.UidName,
-			Value:    uid,
-			Path:     "/",
-			MaxAge:   31536000, // 1 year in seconds
-			Secure:   true,
-			HttpOnly: true,
+		slog.Debug("uid conflict", "uid", h.logUID(id.uid, cfg), "source", id.source, "merged", merged)
+	}
+	uid = id.uid
+	if uid == "" {
+		uid = uuid.New().String()
+		isNewUid = true
+	}
+	if track {
+		http.SetCookie(w, newUIDCookie(cfg, uid, int(cfg.UIDCookie.MaxAge.Seconds())))
+	}
+
+	if cfg.SIDSigning.Enabled {
+		sidToken, sid, sidStatus = h.resolveSID(r, cfg, fileExt == ".m3u8")
+		if sidStatus != sidValid {
+			h.rejectSID(w, r, cfg, path, uid, sid, sidStatus, country)
+			return
 		}
-	} else {
-		uid = uidCookie.Value
-		isNewUid = false
 	}
-	w.Header().Set("Set-Cookie", uidCookie.String())
 
-	file, err := os.Open(path)
-	if err != nil {
-		slog.Error("failed to open file", "error", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	sid, sidToken = h.reuseSession(r, cfg, uid, sid, sidToken)
+
+	if !h.checkRestream(w, r, cfg, sid, uid) {
+		return
+	}
+
+	if !h.limitStreams(w, r, cfg, uid, sid, sidToken, fileExt == ".m3u8") {
 		return
 	}
-	defer file.Close()
 
 	if fileExt != ".m3u8" {
+		chunk, err := h.openChunk(name, info, &cfg.ChunkCache)
+		if err != nil {
+			slog.Error("failed to open file", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer chunk.Close()
+
 		setHeaders(w)
-		w.Header().Set("Content-Type", h.ChunkMIME)
+		w.Header().Set("Content-Type", cfg.ChunkMIME)
 
 		status := http.StatusOK
-		if _, err := io.Copy(w, file); err != nil {
+		if err := h.sendChunk(w, r, cfg, chunk, info.Size(), path); err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			slog.Error("failed to copy file", "error", err)
 			status = http.StatusInternalServerError
 		}
+		ev := chunklog.ChunkEvent{
+			Time:          time.Now(),
+			Path:          path,
+			ChunkSize:     info.Size(),
+			IP:            r.RemoteAddr,
+			UserAgent:     r.UserAgent(),
+			Referer:       r.Referer(),
+			SID:           sid,
+			UID:           uid,
+			ClientSubject: clientSubject(r),
+			Country:       country,
+			MergedUIDs:    id.merged,
+			SIDStatus:     sidStatus,
+		}
+		if user != nil {
+			ev.Claims = user.claims
+		}
+		h.privatize(&ev, r, cfg)
+		if cfg.Restream.Enabled() {
+			ev.Suspect = h.restream.observe(&cfg.Restream, ev.Time, sid, uid, clientIP(r), access.asn(addr), country,
+				chunklog.ChunkDuration(path), ev.UID, ev.IP)
+		}
 		// log only chunks
 		slog.Info("chunk",
 			"status", status,
 			"method", r.Method,
 			"path", path,
-			"size", info.Size(),
-			"ip", r.RemoteAddr,
-			"user-agent", r.UserAgent(),
-			"sid", sid,
-			"uid", uid,
-			"referer", r.Referer(),
+			"size", ev.ChunkSize,
+			"ip", ev.IP,
+			"user-agent", ev.UserAgent,
+			"sid", ev.SID,
+			"uid", ev.UID,
+			"referer", ev.Referer,
+			"client", ev.ClientSubject,
+			"country", ev.Country,
+			"suspect", ev.Suspect,
 		)
 		if h.ChunkWriter != nil {
-			h.ChunkWriter.Send(chunklog.ChunkEvent{
-				Time:      time.Now(),
-				Path:      path,
-				ChunkSize: info.Size(),
-				IP:        r.RemoteAddr,
-				UserAgent: r.UserAgent(),
-				Referer:   r.Referer(),
-				SID:       sid,
-				UID:       uid,
-			})
+			h.ChunkWriter.Send(ev)
 		}
 		return
 	}
 
+	file, err := h.Content.Open(name)
+	if err != nil {
+		slog.Error("failed to open file", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	// The uid can be a token subject; escape it like the token.
+	query := "?" + cfg.SidName + "=" + sidToken + "&" + cfg.UidName + "=" + url.QueryEscape(uid)
+	if user != nil && user.token != "" {
+		// The player only has the token in the URL; pass it on.
+		query += "&" + cfg.Auth.QueryParam + "=" + url.QueryEscape(user.token)
+	}
+
 	scanner := bufio.NewScanner(file)
-	scanner.Buffer(make([]byte, 0, h.BufferSize), h.BufferSize)
-	outBuf := bytes.NewBuffer(make([]byte, 0, h.BufferSize))
+	scanner.Buffer(make([]byte, 0, cfg.BufferSize), cfg.BufferSize)
+	outBuf := bytes.NewBuffer(make([]byte, 0, cfg.BufferSize))
 
 	for scanner.Scan() {
 		var err error
@@ -158,7 +259,7 @@ func (h *HServ) handler(w http.ResponseWriter, r *http.Request) {
 		if strings.HasPrefix(line, "#") {
 			_, err = outBuf.WriteString(line + "\n")
 		} else {
-			_, err = outBuf.WriteString(line + "?" + h.SidName + "=" + sid + "&" + h.UidName + "=" + uid + "\n")
+			_, err = outBuf.WriteString(line + query + "\n")
 		}
 		if err != nil {
 			slog.Error("failed to write output", "error", err)
@@ -181,16 +282,24 @@ func (h *HServ) handler(w http.ResponseWriter, r *http.Request) {
 	}
 	if isNewUid {
 		//TODO: store info in db
+		if cfg.Privacy.PseudonymizeUID {
+			uid = h.pseudo.uid(uid, time.Now())
+		}
 		slog.Info("new uid", "uid", uid)
 	}
 }
 
-func setHeaders(w http.ResponseWriter) {
-	w.Header().Set("Allow", "GET, HEAD")
+// clientIP returns the IP address of the client without the port.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
 
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
-	w.Header().Set("Access-Control-Allow-Headers", "*")
+func setHeaders(w http.ResponseWriter) {
+	w.Header().Set("Allow", "GET, HEAD, OPTIONS")
 
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Pragma", "no-cache")
@@ -3,102 +3,398 @@ package hserv
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
-	"mime"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/uamana/hserv/internal/chunklog"
+	"github.com/uamana/hserv/internal/config"
 )
 
 type HServ struct {
-	Addr         string
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
-	RootDir      string
-	SidName      string
-	UidName      string
-	ChunkExt     string
-	ChunkMIME    string
-	BufferSize   int
-	TLSCertPath  string
-	TLSKeyPath   string
-	ChunkWriter  *chunklog.Writer
+	ChunkWriter *chunklog.Writer
+	// LoadConfig reads the config again on SIGHUP or an admin reload request.
+	// Reloading is disabled when it is nil.
+	LoadConfig func() (*config.Config, error)
+	// Content is where playlists and chunks are read from. If nil, Run opens
+	// the source of the config.
+	Content fs.FS
+
+	cfg       atomic.Pointer[config.Config]
+	kpr       *keypairReloader
+	acme      *acmeSource
+	tlsPolicy atomic.Pointer[tls.Config]
+	access    atomic.Pointer[accessRules]
+	auth      atomic.Pointer[jwtVerifier]
+	pseudo    *pseudonymizer
+	sids      *sidSigner
+	reloadMu  sync.Mutex
+	ready     atomic.Bool
+
+	playlistLimiter tokenBucket
+	chunkLimiter    tokenBucket
+	sessions        sessionTracker
+	restream        restreamDetector
+	egress          egressLimiter
+	chunks          chunkCache
+	origin          *originPuller
+}
+
+// New returns an HServ serving with the given config.
+func New(cfg *config.Config) *HServ {
+	h := &HServ{}
+	h.cfg.Store(cfg)
+	return h
+}
+
+// Config returns the config currently in effect.
+func (h *HServ) Config() *config.Config {
+	return h.cfg.Load()
 }
 
 func (h *HServ) Run(ctx context.Context) (err error) {
-	h.RootDir, err = filepath.Abs(h.RootDir)
-	if err != nil {
-		return fmt.Errorf("failed to get absolute path of root directory: %w", err)
-	}
+	cfg := h.Config()
 
-	if h.ChunkMIME == "" {
-		h.ChunkMIME = mime.TypeByExtension(h.ChunkExt)
+	if cfg.HasCertFiles() {
+		h.kpr, err = NewKeypairReloader(cfg)
+		if err != nil {
+			return err
+		}
 	}
-
-	kpr, err := NewKeypairReloader(ctx, h.TLSCertPath, h.TLSKeyPath)
+	if cfg.ACME.Enabled() {
+		h.acme, err = newACMESource(&cfg.ACME)
+		if err != nil {
+			return err
+		}
+	}
+	if h.pseudo, err = newPseudonymizer(&cfg.Privacy); err != nil {
+		return err
+	}
+	if h.sids, err = newSIDSigner(&cfg.SIDSigning); err != nil {
+		return err
+	}
+	if h.Content == nil {
+		if h.Content, err = newContentSource(cfg); err != nil {
+			return err
+		}
+	}
+	if cfg.Origin.Enabled() {
+		if h.origin, err = newOriginPuller(cfg); err != nil {
+			return err
+		}
+	}
+	access, err := newAccessRules(&cfg.Access, restreamASNDB(cfg), nil)
 	if err != nil {
 		return err
 	}
-
-	srv := &http.Server{
-		Addr:         h.Addr,
-		ReadTimeout:  h.ReadTimeout,
-		WriteTimeout: h.WriteTimeout,
-		Handler:      http.HandlerFunc(h.handler),
-		TLSConfig:    &tls.Config{GetCertificate: kpr.GetCertificateFunc()},
+	h.access.Store(access)
+	auth, err := newJWTVerifier(&cfg.Auth)
+	if err != nil {
+		return err
+	}
+	h.auth.Store(auth)
+	if cfg.Addr != "" {
+		policy, err := h.buildTLSPolicy(cfg)
+		if err != nil {
+			return err
+		}
+		h.tlsPolicy.Store(policy)
 	}
 
 	slog.Info("hserv",
-		"addr", h.Addr,
-		"rootDir", h.RootDir,
-		"sidName", h.SidName,
-		"chunkExt", h.ChunkExt,
-		"chunkMIME", h.ChunkMIME,
-		"bufferSize", h.BufferSize,
-		"tlsCertPath", h.TLSCertPath,
-		"tlsKeyPath", h.TLSKeyPath,
+		"addr", cfg.Addr,
+		"httpAddr", cfg.HTTPAddr,
+		"httpMode", cfg.HTTPMode,
+		"adminAddr", cfg.AdminAddr,
+		"rootDir", cfg.RootDir,
+		"origin", cfg.Origin.URL,
+		"source", cfg.Source.Type,
+		"sidName", cfg.SidName,
+		"chunkExt", cfg.ChunkExt,
+		"chunkMIME", cfg.ChunkMIME,
+		"bufferSize", cfg.BufferSize,
+		"tlsCertPath", cfg.TLSCertPath,
+		"tlsKeyPath", cfg.TLSKeyPath,
+		"tlsCertDir", cfg.CertDir,
+		"tlsCerts", len(cfg.Certs),
+		"acmeDomains", cfg.ACME.Domains,
+		"tlsMinVersion", cfg.TLS.MinVersion,
+		"tlsClientAuth", cfg.TLS.ClientAuth,
 	)
 
 	// Graceful shutdown: wait for SIGINT/SIGTERM (or parent context cancellation),
-	// then drain chunk writer before shutting down the HTTP server.
+	// then drain the HTTP servers before the chunk writer.
 	srvCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	errCh := make(chan error, 1)
-	go func() {
-		errCh <- srv.ListenAndServeTLS("", "")
-	}()
-
-	select {
-	case err := <-errCh:
-		// Server exited on its own (listener error or similar).
-		// Best-effort flush of any pending chunklog events.
-		if h.ChunkWriter != nil {
-			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			h.ChunkWriter.Shutdown(shutdownCtx)
-		}
+	go h.reloadOnSIGHUP(srvCtx)
+	if cfg.Addr != "" && cfg.CertWatchInterval.Duration > 0 {
+		go h.watchTLS(srvCtx, cfg.CertWatchInterval.Duration)
+	}
+
+	addrs := map[string]string{
+		listenerHTTPS: cfg.Addr,
+		listenerHTTP:  cfg.HTTPAddr,
+		listenerAdmin: cfg.AdminAddr,
+	}
+	listeners, order, err := openListeners(addrs)
+	if err != nil {
 		return err
+	}
 
-	case <-srvCtx.Done():
-		// OS signal: first drain the chunklog writer, then gracefully
-		// shut down the HTTP server.
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	var (
+		servers  []*http.Server
+		adminSrv *http.Server
+	)
+	limiter := newConnLimiter(func() (int, int) {
+		c := h.Config()
+		return c.MaxConns, c.MaxConnsPerIP
+	}, func(ip string) string {
+		return anonymizeIPString(ip, &h.Config().Privacy)
+	})
+	errCh := make(chan error, len(order))
+	serve := func(name string, srv *http.Server, useTLS bool) {
+		ln := listeners[name]
+		srv.Addr = ln.Addr().String()
+		if name != listenerAdmin {
+			srv.ReadTimeout = cfg.ReadTimeout.Duration
+			srv.ReadHeaderTimeout = cfg.ReadHeaderTimeout.Duration
+			srv.WriteTimeout = cfg.WriteTimeout.Duration
+			srv.IdleTimeout = cfg.IdleTimeout.Duration
+			ln = limiter.listener(ln)
+		}
+		go func() {
+			var err error
+			if useTLS {
+				err = srv.ServeTLS(ln, "", "")
+			} else {
+				err = srv.Serve(ln)
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				errCh <- fmt.Errorf("%s server: %w", name, err)
+			}
+		}()
+	}
 
-		if h.ChunkWriter != nil {
-			h.ChunkWriter.Shutdown(shutdownCtx)
+	if cfg.Addr != "" {
+		servers = append(servers, &http.Server{
+			Handler:   http.HandlerFunc(h.handler),
+			TLSConfig: h.tlsConfig(),
+		})
+		serve(listenerHTTPS, servers[len(servers)-1], true)
+	}
+	if cfg.HTTPAddr != "" {
+		var handler http.Handler = http.HandlerFunc(h.plainHandler)
+		if h.acme != nil {
+			handler = h.acme.httpHandler(handler)
 		}
+		servers = append(servers, &http.Server{Handler: handler})
+		serve(listenerHTTP, servers[len(servers)-1], false)
+	}
+	if cfg.AdminAddr != "" {
+		adminSrv = &http.Server{Handler: h.adminHandler()}
+		serve(listenerAdmin, adminSrv, false)
+	}
+	h.ready.Store(true)
+	if notifyUpgradeParent() {
+		sdNotify(fmt.Sprintf("MAINPID=%d\nREADY=1", os.Getpid()))
+	} else {
+		sdNotify("READY=1")
+	}
 
-		if err := srv.Shutdown(shutdownCtx); err != nil {
+	upgradeCh := make(chan os.Signal, 1)
+	signal.Notify(upgradeCh, syscall.SIGUSR2)
+	defer signal.Stop(upgradeCh)
+
+	for {
+		select {
+		case err := <-errCh:
+			// A server exited on its own (listener error or similar).
+			// Stop the others and best-effort flush pending chunklog events.
+			sdNotify("STOPPING=1")
+			h.shutdown(servers, adminSrv, 0, h.Config().ShutdownTimeout.Duration)
 			return err
+
+		case <-upgradeCh:
+			slog.Info("received SIGUSR2, starting new process")
+			if err := upgrade(listeners, order); err != nil {
+				slog.Error("upgrade failed, continuing to serve", "error", err)
+				continue
+			}
+			// The new process owns the service now; drain without telling
+			// systemd that it is stopping.
+			return h.shutdown(servers, adminSrv, 0, h.Config().ShutdownTimeout.Duration)
+
+		case <-srvCtx.Done():
+			slog.Info("shutting down")
+			sdNotify("STOPPING=1")
+			cfg := h.Config()
+			return h.shutdown(servers, adminSrv, cfg.ShutdownDrain.Duration, cfg.ShutdownTimeout.Duration)
 		}
-		return nil
+	}
+}
+
+// shutdown stops hserv in an order that never loses an in-flight request:
+// readiness is flipped and, after drain has given load balancers time to
+// notice, the content listeners stop accepting, in-flight requests are
+// drained up to timeout (then their connections are closed), and only then is
+// the chunklog writer drained, so no handler can send to it afterwards. The
+// admin server goes last, so probes see the instance leaving.
+func (h *HServ) shutdown(servers []*http.Server, adminSrv *http.Server, drain, timeout time.Duration) error {
+	h.ready.Store(false)
+	if drain > 0 {
+		slog.Info("not ready, serving until the drain delay is over", "drain", drain)
+		time.Sleep(drain)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(servers))
+	)
+	for i, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				slog.Warn("in-flight requests not finished before shutdown timeout, closing connections", "addr", srv.Addr)
+				errs[i] = srv.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if h.ChunkWriter != nil {
+		writerCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		h.ChunkWriter.Shutdown(writerCtx)
+	}
+
+	if adminSrv != nil {
+		errs = append(errs, adminSrv.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (h *HServ) reloadOnSIGHUP(ctx context.Context) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	defer signal.Stop(c)
+	for {
+		select {
+		case <-c:
+			slog.Info("received SIGHUP, reloading configuration")
+			if _, err := h.Reload(); err != nil {
+				slog.Error("keeping old configuration because the new one could not be loaded", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reload reads the config with LoadConfig and applies every setting that can
+// be changed while running. An invalid config is rejected and the current one
+// is kept. It returns the names of changed settings that need a restart.
+// TLS files are reloaded as well, even if the config is rejected.
+func (h *HServ) Reload() ([]string, error) {
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+
+	var (
+		next *config.Config
+		err  error
+	)
+	if h.LoadConfig == nil {
+		err = errors.New("config reload is not configured")
+	} else {
+		next, err = h.LoadConfig()
+	}
+	if err != nil {
+		h.reloadTLS(h.Config())
+		return nil, err
+	}
+	merged, restart := config.Merge(h.Config(), next)
+	if h.kpr == nil && merged.HasCertFiles() {
+		// Without certificate files at startup there is no reloader to
+		// load them into.
+		if merged.TLSCertPath != "" {
+			restart = append(restart, "cert", "key")
+		}
+		if len(merged.Certs) > 0 {
+			restart = append(restart, "certs")
+		}
+		if merged.CertDir != "" {
+			restart = append(restart, "certDir")
+		}
+		cur := h.Config()
+		merged.TLSCertPath, merged.TLSKeyPath = cur.TLSCertPath, cur.TLSKeyPath
+		merged.Certs, merged.CertDir = cur.Certs, cur.CertDir
+	}
+	access, err := newAccessRules(&merged.Access, restreamASNDB(merged), h.access.Load())
+	if err != nil {
+		h.reloadTLS(h.Config())
+		return nil, err
+	}
+	auth, err := newJWTVerifier(&merged.Auth)
+	if err != nil {
+		h.reloadTLS(h.Config())
+		return nil, err
+	}
+	var policy *tls.Config
+	if merged.Addr != "" {
+		if policy, err = h.buildTLSPolicy(merged); err != nil {
+			h.reloadTLS(h.Config())
+			return nil, err
+		}
+		h.reloadCerts(merged)
+		h.tlsPolicy.Store(policy)
+	}
+	h.access.Store(access)
+	h.auth.Store(auth)
+	level, _ := merged.Level()
+	slog.SetLogLoggerLevel(level)
+	h.cfg.Store(merged)
+	h.chunks.trim(merged.ChunkCache.Bytes())
+
+	if len(restart) > 0 {
+		slog.Warn("configuration reloaded, some changes need a restart", "restartRequired", restart)
+	} else {
+		slog.Info("configuration reloaded")
+	}
+	return restart, nil
+}
+
+// reloadTLS reloads certificates and the TLS policy files of cfg, keeping
+// whatever fails to load.
+func (h *HServ) reloadTLS(cfg *config.Config) {
+	if cfg.Addr == "" {
+		return
+	}
+	h.reloadCerts(cfg)
+	policy, err := h.buildTLSPolicy(cfg)
+	if err != nil {
+		slog.Error("keeping old TLS policy because it could not be loaded", "error", err)
+		return
+	}
+	h.tlsPolicy.Store(policy)
+}
+
+func (h *HServ) reloadCerts(cfg *config.Config) {
+	if h.kpr == nil {
+		return
+	}
+	if err := h.kpr.maybeReload(cfg); err != nil {
+		slog.Error("keeping old TLS certificates that could not be loaded", "error", err)
 	}
 }
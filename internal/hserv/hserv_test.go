@@ -0,0 +1,75 @@
+package hserv
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+// newTestHServ returns an HServ serving content with cfg, set up like Run
+// does without opening listeners.
+func newTestHServ(t *testing.T, cfg *config.Config, content fs.FS) *HServ {
+	t.Helper()
+	h := New(cfg)
+	h.Content = content
+	var err error
+	if h.pseudo, err = newPseudonymizer(&cfg.Privacy); err != nil {
+		t.Fatal(err)
+	}
+	if h.sids, err = newSIDSigner(&cfg.SIDSigning); err != nil {
+		t.Fatal(err)
+	}
+	access, err := newAccessRules(&cfg.Access, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.access.Store(access)
+	auth, err := newJWTVerifier(&cfg.Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.auth.Store(auth)
+	return h
+}
+
+// testClient is a player with its own address and user agent.
+type testClient struct {
+	addr, userAgent string
+}
+
+func (c testClient) request(target string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "https://hserv.test"+target, nil)
+	r.RemoteAddr = c.addr
+	r.Header.Set("User-Agent", c.userAgent)
+	return r
+}
+
+func (c testClient) get(h *HServ, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.handler(w, c.request(target))
+	return w
+}
+
+// playlistQuery returns the query hserv added to the first URI of a
+// playlist.
+func playlistQuery(t *testing.T, body string) url.Values {
+	t.Helper()
+	for _, line := range strings.Split(body, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		_, query, _ := strings.Cut(line, "?")
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}
+	t.Fatalf("no URI in playlist %q", body)
+	return nil
+}
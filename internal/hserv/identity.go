@@ -0,0 +1,60 @@
+package hserv
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+var uidConflicts = expvar.NewMap("uid_conflicts")
+
+// identity is the uid of a request, resolved from its sources.
+type identity struct {
+	uid    string
+	source string
+	// merged are the different uids of the sources that lost.
+	merged []string
+}
+
+// resolveIdentity picks the uid of the first source in cfg.IdentityOrder
+// that has one. auth is the uid of an authenticated listener, if any; the
+// cookie is ignored unless useCookie.
+func resolveIdentity(r *http.Request, cfg *config.Config, auth string, useCookie bool) identity {
+	uids := map[string]string{
+		config.IdentityAuth:  auth,
+		config.IdentityQuery: r.URL.Query().Get(cfg.UidName),
+	}
+	if c, err := r.Cookie(cfg.UIDCookie.Name); err == nil && useCookie {
+		uids[config.IdentityCookie] = c.Value
+	}
+
+	var id identity
+	for _, source := range cfg.IdentityOrder {
+		uid := uids[source]
+		switch {
+		case uid == "":
+		case id.uid == "":
+			id.uid, id.source = uid, source
+		case uid != id.uid:
+			id.merged = append(id.merged, uid)
+		}
+	}
+	return id
+}
+
+// newUIDCookie returns the uid cookie with the configured attributes. A
+// negative maxAge deletes it.
+func newUIDCookie(cfg *config.Config, uid string, maxAge int) *http.Cookie {
+	c := &cfg.UIDCookie
+	return &http.Cookie{
+		Name:     c.Name,
+		Value:    uid,
+		Domain:   c.Domain,
+		Path:     c.Path,
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: c.SameSiteMode(),
+	}
+}
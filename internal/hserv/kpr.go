@@ -1,66 +1,162 @@
 package hserv
 
 import (
-	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"log/slog"
-	"os"
-	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
-	"syscall"
+
+	"github.com/uamana/hserv/internal/config"
 )
 
+// keypairReloader serves a set of certificates selected by SNI. The pair
+// given by -cert/-key (or the first one loaded) is the default for clients
+// that send no or an unknown server name.
 type keypairReloader struct {
-	certMu   sync.RWMutex
-	cert     *tls.Certificate
-	certPath string
-	keyPath  string
+	loadMu sync.Mutex
+	certMu sync.RWMutex
+	certs  map[string]*tls.Certificate // by certificate path
+	names  map[string][]*tls.Certificate
+	def    *tls.Certificate
 }
 
-func NewKeypairReloader(ctx context.Context, certPath, keyPath string) (*keypairReloader, error) {
-	result := &keypairReloader{
-		certPath: certPath,
-		keyPath:  keyPath,
-	}
-	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
-	if err != nil {
-		return nil, err
-	}
-	result.cert = &cert
-	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGHUP)
-		defer signal.Stop(c)
-		for {
-			select {
-			case <-c:
-				slog.Info("received SIGHUP, reloading TLS certificate and key")
-				if err := result.maybeReload(); err != nil {
-					slog.Error("keeping old TLS certificate because the new one could not be loaded", "error", err)
-				}
-			case <-ctx.Done():
-				return
-			}
+func NewKeypairReloader(cfg *config.Config) (*keypairReloader, error) {
+	result := &keypairReloader{}
+	if err := result.maybeReload(cfg); err != nil {
+		if result.def == nil {
+			return nil, err
 		}
-	}()
+		slog.Error("some TLS certificates could not be loaded", "error", err)
+	}
+	if cfg.TLSCertPath != "" && result.certs[cfg.TLSCertPath] == nil {
+		return nil, fmt.Errorf("failed to load default TLS certificate %s", cfg.TLSCertPath)
+	}
 	return result, nil
 }
 
-func (kpr *keypairReloader) maybeReload() error {
-	newCert, err := tls.LoadX509KeyPair(kpr.certPath, kpr.keyPath)
+// certPairs lists the configured cert/key pairs, the default pair first.
+// Every *.crt file in cfg.CertDir is paired with the *.key file of the same name.
+func certPairs(cfg *config.Config) ([]config.CertPair, error) {
+	var pairs []config.CertPair
+	if cfg.TLSCertPath != "" {
+		pairs = append(pairs, config.CertPair{Cert: cfg.TLSCertPath, Key: cfg.TLSKeyPath})
+	}
+	pairs = append(pairs, cfg.Certs...)
+	if cfg.CertDir == "" {
+		return pairs, nil
+	}
+	files, err := filepath.Glob(filepath.Join(cfg.CertDir, "*.crt"))
 	if err != nil {
-		return err
+		return pairs, err
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		pairs = append(pairs, config.CertPair{Cert: f, Key: strings.TrimSuffix(f, ".crt") + ".key"})
+	}
+	return pairs, nil
+}
+
+// maybeReload loads every configured pair. A pair that fails to load keeps its
+// previously loaded certificate, so one broken file doesn't affect the others.
+// Pairs no longer configured are dropped.
+func (kpr *keypairReloader) maybeReload(cfg *config.Config) error {
+	kpr.loadMu.Lock()
+	defer kpr.loadMu.Unlock()
+
+	pairs, err := certPairs(cfg)
+	var errs []error
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to list certificate directory: %w", err))
+	}
+
+	kpr.certMu.RLock()
+	old := kpr.certs
+	kpr.certMu.RUnlock()
+
+	certs := make(map[string]*tls.Certificate, len(pairs))
+	names := make(map[string][]*tls.Certificate)
+	var def *tls.Certificate
+	for _, p := range pairs {
+		cert, err := loadKeyPair(p)
+		if err != nil {
+			errs = append(errs, err)
+			if cert = old[p.Cert]; cert == nil {
+				continue
+			}
+		}
+		certs[p.Cert] = cert
+		if def == nil {
+			def = cert
+		}
+		for _, name := range certNames(cert) {
+			names[name] = append(names[name], cert)
+		}
+	}
+	if def == nil {
+		errs = append(errs, errors.New("no TLS certificates loaded"))
+		return errors.Join(errs...)
 	}
+
 	kpr.certMu.Lock()
 	defer kpr.certMu.Unlock()
-	kpr.cert = &newCert
-	return nil
+	kpr.certs = certs
+	kpr.names = names
+	kpr.def = def
+	return errors.Join(errs...)
+}
+
+func loadKeyPair(p config.CertPair) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(p.Cert, p.Key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.Cert, err)
+	}
+	if cert.Leaf == nil {
+		return nil, fmt.Errorf("%s: missing leaf certificate", p.Cert)
+	}
+	return &cert, nil
 }
 
-func (kpr *keypairReloader) GetCertificateFunc() func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
-	return func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		kpr.certMu.RLock()
-		defer kpr.certMu.RUnlock()
-		return kpr.cert, nil
+// certNames returns the lower-cased DNS names of the certificate, falling
+// back to the common name when it has no SANs.
+func certNames(cert *tls.Certificate) []string {
+	names := cert.Leaf.DNSNames
+	if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+		names = []string{cert.Leaf.Subject.CommonName}
+	}
+	result := make([]string, 0, len(names))
+	for _, n := range names {
+		result = append(result, strings.ToLower(n))
+	}
+	return result
+}
+
+// lookup returns the certificate for the SNI server name: an exact match
+// first, then a wildcard one level up, then the default certificate.
+func (kpr *keypairReloader) lookup(hello *tls.ClientHelloInfo) *tls.Certificate {
+	kpr.certMu.RLock()
+	defer kpr.certMu.RUnlock()
+
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if name == "" {
+		return kpr.def
+	}
+	candidates := kpr.names[name]
+	if len(candidates) == 0 {
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			candidates = kpr.names["*"+name[i:]]
+		}
+	}
+	for _, c := range candidates {
+		if hello.SupportsCertificate(c) == nil {
+			return c
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0]
 	}
+	return kpr.def
 }
@@ -0,0 +1,101 @@
+package hserv
+
+import (
+	"expvar"
+	"log/slog"
+	"net"
+	"sync"
+)
+
+var (
+	connsActive   = expvar.NewInt("conns_active")
+	connsRejected = expvar.NewMap("conns_rejected")
+)
+
+// connLimiter caps the number of open connections, in total and per client
+// IP, over all listeners it wraps.
+type connLimiter struct {
+	// limits returns the current maximum of connections in total and per IP,
+	// zero meaning unlimited.
+	limits func() (total, perIP int)
+	// logIP returns an IP as it may be logged.
+	logIP func(ip string) string
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+func newConnLimiter(limits func() (int, int), logIP func(string) string) *connLimiter {
+	return &connLimiter{limits: limits, logIP: logIP, perIP: make(map[string]int)}
+}
+
+// limitListener closes connections over a limit right after accept.
+type limitListener struct {
+	net.Listener
+	l *connLimiter
+}
+
+func (l *connLimiter) listener(ln net.Listener) net.Listener {
+	return &limitListener{Listener: ln, l: l}
+}
+
+func (ll *limitListener) Accept() (net.Conn, error) {
+	l := ll.l
+	for {
+		c, err := ll.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip, _, err := net.SplitHostPort(c.RemoteAddr().String())
+		if err != nil {
+			ip = c.RemoteAddr().String()
+		}
+		if reason := l.acquire(ip); reason != "" {
+			connsRejected.Add(reason, 1)
+			slog.Debug("connection rejected", "reason", reason, "ip", l.logIP(ip))
+			c.Close()
+			continue
+		}
+		return &limitConn{Conn: c, release: func() { l.release(ip) }}, nil
+	}
+}
+
+// acquire counts a new connection from ip, or returns the limit it exceeds.
+func (l *connLimiter) acquire(ip string) string {
+	maxTotal, maxPerIP := l.limits()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if maxTotal > 0 && l.total >= maxTotal {
+		return "total"
+	}
+	if maxPerIP > 0 && l.perIP[ip] >= maxPerIP {
+		return "per_ip"
+	}
+	l.total++
+	l.perIP[ip]++
+	connsActive.Add(1)
+	return ""
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+	connsActive.Add(-1)
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,210 @@
+package hserv
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the listeners, used as LISTEN_FDNAMES (FileDescriptorName= in a
+// systemd socket unit).
+const (
+	listenerHTTPS = "https"
+	listenerHTTP  = "http"
+	listenerAdmin = "admin"
+)
+
+// listenFdsStart is the first file descriptor passed with LISTEN_FDS.
+const listenFdsStart = 3
+
+// upgradeFdEnv names the pipe a new process signals readiness on during an
+// upgrade.
+const upgradeFdEnv = "HSERV_UPGRADE_FD"
+
+// upgradeReadyTimeout is how long the old process waits for the new one.
+const upgradeReadyTimeout = 30 * time.Second
+
+// inheritedListeners returns the listeners passed with the systemd socket
+// activation protocol (LISTEN_FDS), either by systemd or by the previous
+// process on upgrade, keyed by name. Descriptors without a name get the
+// names from wanted in order. The LISTEN_* variables are cleared afterwards.
+func inheritedListeners(wanted []string) (map[string]net.Listener, error) {
+	fds := os.Getenv("LISTEN_FDS")
+	pid := os.Getenv("LISTEN_PID")
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if fds == "" || (pid != "" && pid != strconv.Itoa(os.Getpid())) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	result := make(map[string]net.Listener, n)
+	unnamed := slices.Clone(wanted)
+	for i := 0; i < n; i++ {
+		f := os.NewFile(uintptr(listenFdsStart+i), "listener")
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited file descriptor %d is not a listener: %w", listenFdsStart+i, err)
+		}
+		name := ""
+		if i < len(names) && names[i] != "" && names[i] != "unknown" {
+			name = names[i]
+		} else if len(unnamed) > 0 {
+			name = unnamed[0]
+		}
+		unnamed = slices.DeleteFunc(unnamed, func(s string) bool { return s == name })
+		if !slices.Contains(wanted, name) || result[name] != nil {
+			slog.Warn("ignoring inherited listener", "name", name, "addr", ln.Addr())
+			ln.Close()
+			continue
+		}
+		slog.Info("using inherited listener", "name", name, "addr", ln.Addr())
+		result[name] = ln
+	}
+	return result, nil
+}
+
+// openListeners returns a listener for every non-empty address in addrs,
+// inherited if possible, and their names in a fixed order.
+func openListeners(addrs map[string]string) (map[string]net.Listener, []string, error) {
+	var order []string
+	for _, name := range []string{listenerHTTPS, listenerHTTP, listenerAdmin} {
+		if addrs[name] != "" {
+			order = append(order, name)
+		}
+	}
+	listeners, err := inheritedListeners(order)
+	if err != nil {
+		return nil, nil, err
+	}
+	if listeners == nil {
+		listeners = make(map[string]net.Listener, len(order))
+	}
+	for _, name := range order {
+		if listeners[name] != nil {
+			continue
+		}
+		ln, err := net.Listen("tcp", addrs[name])
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, nil, fmt.Errorf("%s listener: %w", name, err)
+		}
+		listeners[name] = ln
+	}
+	return listeners, order, nil
+}
+
+// upgrade starts a new hserv process from the current executable path with
+// the same arguments and passes it the listening sockets, so no connection
+// is refused while binaries are swapped. It returns once the new process is
+// ready; the caller then drains and exits.
+func upgrade(listeners map[string]net.Listener, order []string) error {
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+
+	var (
+		files []*os.File
+		names []string
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, name := range order {
+		ln, ok := listeners[name].(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener %s can't be passed on", name)
+		}
+		f, err := ln.File()
+		if err != nil {
+			return fmt.Errorf("failed to get file of listener %s: %w", name, err)
+		}
+		files = append(files, f)
+		names = append(names, name)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, "LISTEN_") || strings.HasPrefix(kv, upgradeFdEnv+"=")
+	})
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(env,
+		"LISTEN_FDS="+strconv.Itoa(len(names)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		upgradeFdEnv+"="+strconv.Itoa(listenFdsStart+len(names)),
+	)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start new process: %w", err)
+	}
+	readyW.Close()
+	files = files[:len(files)-1]
+	go cmd.Wait()
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyR.Read(buf)
+		ready <- err
+	}()
+	select {
+	case err := <-ready:
+		if err == nil {
+			slog.Info("new process is ready", "pid", cmd.Process.Pid)
+			return nil
+		}
+		err = fmt.Errorf("new process exited before it was ready: %w", err)
+		cmd.Process.Kill()
+		return err
+	case <-time.After(upgradeReadyTimeout):
+		cmd.Process.Kill()
+		return errors.New("new process not ready in time")
+	}
+}
+
+// notifyUpgradeParent tells the previous process that this one is serving.
+// It reports whether hserv was started by an upgrade.
+func notifyUpgradeParent() bool {
+	fd := os.Getenv(upgradeFdEnv)
+	os.Unsetenv(upgradeFdEnv)
+	if fd == "" {
+		return false
+	}
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		slog.Error("invalid upgrade file descriptor", "fd", fd)
+		return false
+	}
+	f := os.NewFile(uintptr(n), "upgrade")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		slog.Error("failed to notify previous process", "error", err)
+	}
+	return true
+}
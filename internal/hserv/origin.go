@@ -0,0 +1,240 @@
+package hserv
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+var originStats = expvar.NewMap("origin")
+
+// errOriginNotFound is returned for files the origin doesn't have.
+var errOriginNotFound = errors.New("not found on origin")
+
+// originSweepEvery is how often pulled files past their retention are
+// removed and failed fetches forgotten.
+const originSweepEvery = time.Minute
+
+// originPuller pulls playlists and chunks from the origin into the root
+// directory. Concurrent requests for the same file share one fetch.
+type originPuller struct {
+	base      string
+	client    *http.Client
+	root      string
+	mu        sync.Mutex
+	files     map[string]*originFile // relative path -> state
+	lastSweep time.Time
+}
+
+type originFile struct {
+	mu      sync.Mutex
+	fetched time.Time
+	err     error
+}
+
+func newOriginPuller(cfg *config.Config) (*originPuller, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.Origin.CABundle != "" {
+		pem, err := os.ReadFile(cfg.Origin.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read origin CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in origin CA bundle")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &originPuller{
+		base:   strings.TrimSuffix(cfg.Origin.URL, "/"),
+		client: &http.Client{Transport: transport, Timeout: cfg.Origin.Timeout.Duration},
+		root:   cfg.RootDir,
+		files:  make(map[string]*originFile),
+	}, nil
+}
+
+// pull makes sure the file at rel, relative to the root directory, is
+// present and, for playlists, fresh. A playlist that can't be refreshed is
+// served stale.
+func (o *originPuller) pull(cfg *config.Origin, rel string, isPlaylist bool) error {
+	now := time.Now()
+	o.mu.Lock()
+	if now.Sub(o.lastSweep) >= originSweepEvery {
+		o.lastSweep = now
+		go o.sweep(cfg)
+	}
+	f := o.files[rel]
+	if f == nil {
+		f = &originFile{}
+		o.files[rel] = f
+	}
+	o.mu.Unlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fetched.IsZero() {
+		// The sweep may have dropped f while this request waited for it.
+		o.mu.Lock()
+		if o.files[rel] == nil {
+			o.files[rel] = f
+		}
+		o.mu.Unlock()
+	}
+	// Requests that waited for a fetch find the file fresh; failures are
+	// retried after the playlist TTL.
+	age := time.Since(f.fetched)
+	switch {
+	case f.fetched.IsZero():
+	case f.err != nil:
+		if age < cfg.PlaylistTTL.Duration {
+			return f.err
+		}
+	case !isPlaylist || age < cfg.PlaylistTTL.Duration:
+		originStats.Add("hits", 1)
+		return nil
+	}
+
+	err := o.fetch(rel, isPlaylist)
+	if errors.Is(err, errOriginNotFound) {
+		// Not remembered, the file may appear on the origin any time. A copy
+		// pulled before is gone from the origin too.
+		if f.err == nil && !f.fetched.IsZero() {
+			if err := os.Remove(filepath.Join(o.root, rel)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				slog.Error("failed to remove pulled file", "path", rel, "error", err)
+			}
+		}
+		f.fetched, f.err = time.Time{}, nil
+		o.mu.Lock()
+		if o.files[rel] == f {
+			delete(o.files, rel)
+		}
+		o.mu.Unlock()
+		return err
+	}
+	if err != nil && isPlaylist && f.err == nil && !f.fetched.IsZero() {
+		slog.Warn("serving stale playlist, origin failed", "path", rel, "error", err)
+		originStats.Add("stale", 1)
+		return nil
+	}
+	f.fetched, f.err = time.Now(), err
+	return err
+}
+
+// fetch downloads rel from the origin and replaces the local file.
+// It doesn't follow the request that triggered it, whose fetch others may be
+// waiting for.
+func (o *originPuller) fetch(rel string, isPlaylist bool) error {
+	originStats.Add("fetches", 1)
+	resp, err := o.client.Get(o.base + "/" + filepath.ToSlash(rel))
+	if err != nil {
+		originStats.Add("errors", 1)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errOriginNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		originStats.Add("errors", 1)
+		return fmt.Errorf("origin answered %s", resp.Status)
+	}
+
+	var body io.Reader = resp.Body
+	if isPlaylist {
+		b, err := stripPlaylistQueries(resp.Body)
+		if err != nil {
+			originStats.Add("errors", 1)
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	// Write a temporary file and rename it, so readers never see a partial
+	// file.
+	path := filepath.Join(o.root, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".pull-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		originStats.Add("errors", 1)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// stripPlaylistQueries drops the query of relative URIs in a playlist. An
+// origin hserv adds its own sid and uid, which the edge adds again.
+func stripPlaylistQueries(r io.Reader) ([]byte, error) {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "#") && !strings.Contains(line, "://") {
+			line, _, _ = strings.Cut(line, "?")
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.Bytes(), scanner.Err()
+}
+
+// sweep forgets failed fetches once they may be retried and removes pulled
+// files fetched more than RetainFor ago. Only files pulled by this process
+// are removed.
+func (o *originPuller) sweep(cfg *config.Origin) {
+	now := time.Now()
+	var remove []string
+	o.mu.Lock()
+	for rel, f := range o.files {
+		if !f.mu.TryLock() {
+			// Being fetched right now.
+			continue
+		}
+		age := now.Sub(f.fetched)
+		switch {
+		case f.fetched.IsZero():
+		case f.err != nil && age >= cfg.PlaylistTTL.Duration:
+			delete(o.files, rel)
+		case f.err == nil && age >= cfg.RetainFor.Duration:
+			delete(o.files, rel)
+			remove = append(remove, filepath.Join(o.root, rel))
+			// Requests still holding f fetch again.
+			f.fetched = time.Time{}
+		}
+		f.mu.Unlock()
+	}
+	o.mu.Unlock()
+
+	for _, path := range remove {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("failed to remove pulled file", "path", path, "error", err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package hserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+const (
+	testChunk    = "mp3_hifi_1700000000_10.0_1.ts"
+	testPlaylist = "#EXTM3U\n#EXTINF:10.0,\n" + testChunk + "?sid=origin-sid&uid=origin-uid\n"
+)
+
+// testUpstream is an origin serving files from memory. It counts the
+// requests for every path, answers 502 while failing and holds requests
+// while hold is not nil.
+type testUpstream struct {
+	*httptest.Server
+	mu       sync.Mutex
+	files    map[string]string
+	requests map[string]int
+	failing  bool
+	hold     chan struct{}
+}
+
+func newTestUpstream(t *testing.T) *testUpstream {
+	up := &testUpstream{
+		files:    map[string]string{"live/s.m3u8": testPlaylist, "live/" + testChunk: "chunk"},
+		requests: make(map[string]int),
+	}
+	up.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		up.mu.Lock()
+		up.requests[name]++
+		body, ok := up.files[name]
+		failing, hold := up.failing, up.hold
+		up.mu.Unlock()
+		if hold != nil {
+			<-hold
+		}
+		switch {
+		case failing:
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		case !ok:
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte(body))
+		}
+	}))
+	t.Cleanup(up.Close)
+	return up
+}
+
+func (up *testUpstream) count(name string) int {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	return up.requests[name]
+}
+
+func (up *testUpstream) set(f func(up *testUpstream)) {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	f(up)
+}
+
+// newTestEdge returns an edge hserv pulling from up into a temporary root
+// directory.
+func newTestEdge(t *testing.T, up *testUpstream) (*HServ, string) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.RootDir = t.TempDir()
+	cfg.Origin = config.Origin{
+		URL:         up.URL,
+		PlaylistTTL: config.Duration{Duration: 200 * time.Millisecond},
+		RetainFor:   config.Duration{Duration: time.Hour},
+		Timeout:     config.Duration{Duration: 5 * time.Second},
+	}
+	h := newTestHServ(t, cfg, os.DirFS(cfg.RootDir))
+	var err error
+	if h.origin, err = newOriginPuller(cfg); err != nil {
+		t.Fatal(err)
+	}
+	// Sweeps are run by the tests.
+	h.origin.lastSweep = time.Now()
+	return h, cfg.RootDir
+}
+
+var testListener = testClient{addr: "192.0.2.1:40000", userAgent: "player"}
+
+func TestOriginCoalescesFetches(t *testing.T) {
+	up := newTestUpstream(t)
+	h, _ := newTestEdge(t, up)
+	hold := make(chan struct{})
+	up.set(func(up *testUpstream) { up.hold = hold })
+
+	const n = 10
+	codes := make(chan int, n)
+	for range n {
+		go func() { codes <- testListener.get(h, "/live/"+testChunk).Code }()
+	}
+	for up.count("live/"+testChunk) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// Give the other requests time to queue behind the fetch.
+	time.Sleep(20 * time.Millisecond)
+	close(hold)
+	for range n {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("chunk: %d", code)
+		}
+	}
+	if c := up.count("live/" + testChunk); c != 1 {
+		t.Errorf("origin got %d requests for the chunk, want 1", c)
+	}
+}
+
+func TestOriginPlaylistTTL(t *testing.T) {
+	up := newTestUpstream(t)
+	h, _ := newTestEdge(t, up)
+
+	w := testListener.get(h, "/live/s.m3u8")
+	if w.Code != http.StatusOK {
+		t.Fatalf("playlist: %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "origin-sid") {
+		t.Errorf("the origin's query is served: %q", w.Body.String())
+	}
+	testListener.get(h, "/live/s.m3u8")
+	if c := up.count("live/s.m3u8"); c != 1 {
+		t.Fatalf("origin got %d playlist requests within the TTL, want 1", c)
+	}
+
+	up.set(func(up *testUpstream) {
+		up.files["live/s.m3u8"] = strings.Replace(testPlaylist, "_1.ts", "_2.ts", 1)
+	})
+	time.Sleep(250 * time.Millisecond)
+	w = testListener.get(h, "/live/s.m3u8")
+	if c := up.count("live/s.m3u8"); c != 2 {
+		t.Errorf("origin got %d playlist requests after the TTL, want 2", c)
+	}
+	if !strings.Contains(w.Body.String(), "_2.ts") {
+		t.Errorf("refetched playlist not served: %q", w.Body.String())
+	}
+}
+
+func TestOriginNotFoundNotCached(t *testing.T) {
+	up := newTestUpstream(t)
+	h, root := newTestEdge(t, up)
+	const missing = "live/mp3_hifi_1700000010_10.0_2.ts"
+
+	for i := 1; i <= 2; i++ {
+		if code := testListener.get(h, "/"+missing).Code; code != http.StatusNotFound {
+			t.Fatalf("missing chunk: %d, want 404", code)
+		}
+		if c := up.count(missing); c != i {
+			t.Fatalf("origin got %d requests for the missing chunk, want %d", c, i)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, missing)); !os.IsNotExist(err) {
+		t.Errorf("missing chunk stored: %v", err)
+	}
+
+	up.set(func(up *testUpstream) { up.files[missing] = "late chunk" })
+	w := testListener.get(h, "/"+missing)
+	if w.Code != http.StatusOK || w.Body.String() != "late chunk" {
+		t.Errorf("chunk after it appeared: %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestOriginStalePlaylist(t *testing.T) {
+	up := newTestUpstream(t)
+	h, _ := newTestEdge(t, up)
+
+	if code := testListener.get(h, "/live/s.m3u8").Code; code != http.StatusOK {
+		t.Fatalf("playlist: %d", code)
+	}
+	up.set(func(up *testUpstream) { up.failing = true })
+	time.Sleep(250 * time.Millisecond)
+	w := testListener.get(h, "/live/s.m3u8")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), testChunk) {
+		t.Errorf("stale playlist: %d %q", w.Code, w.Body.String())
+	}
+	if c := up.count("live/s.m3u8"); c != 2 {
+		t.Errorf("origin got %d playlist requests, want 2", c)
+	}
+
+	// A file never pulled can't be served stale.
+	const other = "live/mp3_hifi_1700000010_10.0_2.ts"
+	if code := testListener.get(h, "/"+other).Code; code != http.StatusBadGateway {
+		t.Errorf("chunk from a failing origin: %d, want 502", code)
+	}
+}
+
+func TestOriginSweep(t *testing.T) {
+	up := newTestUpstream(t)
+	h, root := newTestEdge(t, up)
+	unrelated := filepath.Join(root, "live", "recording.ts")
+	if err := os.MkdirAll(filepath.Dir(unrelated), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(unrelated, []byte("not pulled"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(unrelated, old, old)
+
+	if code := testListener.get(h, "/live/"+testChunk).Code; code != http.StatusOK {
+		t.Fatalf("chunk: %d", code)
+	}
+	pulled := filepath.Join(root, "live", testChunk)
+	if _, err := os.Stat(pulled); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := h.Config().Origin
+	h.origin.sweep(&cfg)
+	if _, err := os.Stat(pulled); err != nil {
+		t.Errorf("chunk removed within its retention: %v", err)
+	}
+	cfg.RetainFor.Duration = time.Nanosecond
+	h.origin.sweep(&cfg)
+	if _, err := os.Stat(pulled); !os.IsNotExist(err) {
+		t.Errorf("chunk kept past its retention: %v", err)
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("file not pulled by the edge removed: %v", err)
+	}
+
+	// The removed chunk is pulled again when requested.
+	if code := testListener.get(h, "/live/"+testChunk).Code; code != http.StatusOK {
+		t.Errorf("chunk after the sweep: %d", code)
+	}
+	if c := up.count("live/" + testChunk); c != 2 {
+		t.Errorf("origin got %d requests for the chunk, want 2", c)
+	}
+}
@@ -0,0 +1,114 @@
+package hserv
+
+import (
+	"expvar"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uamana/hserv/internal/chunklog"
+	"github.com/uamana/hserv/internal/config"
+)
+
+var pacingStats = expvar.NewMap("pacing")
+
+// pacingBlock is the size of the writes of a paced chunk response.
+const pacingBlock = 16 << 10
+
+// egressLimiter is a token bucket of bytes shared by all chunk responses, with
+// a burst of one second. The rate is passed on every call, so it follows
+// config reloads.
+type egressLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes n bytes at rate bytes per second and returns how long to wait
+// before sending them. Waiting callers queue up behind each other.
+func (e *egressLimiter) reserve(n int, rate float64, now time.Time) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.last.IsZero() {
+		e.tokens = rate
+	} else {
+		e.tokens = math.Min(rate, e.tokens+now.Sub(e.last).Seconds()*rate)
+	}
+	e.last = now
+	e.tokens -= float64(n)
+	if e.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-e.tokens / rate * float64(time.Second))
+}
+
+// sendChunk copies the chunk at path from file to w. With pacing enabled it
+// is sent at a multiple of its bitrate and within the global egress cap.
+func (h *HServ) sendChunk(w http.ResponseWriter, r *http.Request, cfg *config.Config, file io.Reader, size int64, path string) error {
+	p := &cfg.Pacing
+	if !p.Enabled() {
+		_, err := io.Copy(w, file)
+		return err
+	}
+	pacingStats.Add("responses", 1)
+
+	var rate float64 // bytes per second of this response
+	if d := chunklog.ChunkDuration(path); p.Factor > 0 && d > 0 {
+		rate = float64(size) / d.Seconds() * p.Factor
+	}
+	egress := p.MaxEgress * 1e6 / 8
+	initial := int64(p.InitialBytes)
+
+	rc := http.NewResponseController(w)
+	buf := make([]byte, pacingBlock)
+	start := time.Now()
+	var sent int64
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			now := time.Now()
+			var wait time.Duration
+			if rate > 0 && sent >= initial {
+				wait = start.Add(time.Duration(float64(sent-initial) / rate * float64(time.Second))).Sub(now)
+			}
+			if egress > 0 {
+				if capped := h.egress.reserve(n, egress, now); capped > wait {
+					pacingStats.Add("egress_wait_ms", capped.Milliseconds())
+					wait = capped
+				}
+			}
+			if wait > 0 {
+				// Hand over what was written so far before pausing.
+				_ = rc.Flush()
+				if err := sleepCtx(r, wait); err != nil {
+					return err
+				}
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			sent += int64(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sleepCtx waits for d or until the request is canceled.
+func sleepCtx(r *http.Request, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+}
@@ -0,0 +1,51 @@
+package hserv
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+// plainHandler handles requests on the plain HTTP listener: it either serves
+// them like the TLS listener or redirects them to HTTPS, keeping path and query.
+func (h *HServ) plainHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := h.Config()
+	if cfg.HTTPMode == config.HTTPModeServe {
+		h.handler(w, r)
+		return
+	}
+
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.Trim(host, "[]")
+	if host == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if port := cfg.HTTPSPort(); port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// setHSTS sets the Strict-Transport-Security header on TLS responses.
+func setHSTS(w http.ResponseWriter, r *http.Request, hsts *config.HSTS) {
+	if r.TLS == nil || hsts.MaxAge.Duration <= 0 {
+		return
+	}
+	value := "max-age=" + strconv.FormatInt(int64(hsts.MaxAge.Seconds()), 10)
+	if hsts.IncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if hsts.Preload {
+		value += "; preload"
+	}
+	w.Header().Set("Strict-Transport-Security", value)
+}
@@ -0,0 +1,191 @@
+package hserv
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uamana/hserv/internal/chunklog"
+	"github.com/uamana/hserv/internal/config"
+)
+
+// pseudonymizer replaces uids by keyed hashes. The key of each rotation
+// period is derived from the secret and the period number, so instances
+// sharing the secret agree on the pseudonyms.
+type pseudonymizer struct {
+	secret   []byte
+	rotation time.Duration
+
+	mu     sync.Mutex
+	period int64
+	key    []byte
+}
+
+func newPseudonymizer(p *config.Privacy) (*pseudonymizer, error) {
+	ps := &pseudonymizer{rotation: p.SaltRotation.Duration, period: -1}
+	if p.SaltFile == "" {
+		ps.secret = make([]byte, 32)
+		rand.Read(ps.secret)
+		return ps, nil
+	}
+	var err error
+	if ps.secret, err = readSecret(p.SaltFile); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// readSecret reads a secret key of at least 16 bytes from a file.
+func readSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret := []byte(strings.TrimSpace(string(data)))
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("%s: secret must be at least 16 bytes", path)
+	}
+	return secret, nil
+}
+
+// uid returns the pseudonym of uid at time now, formatted as a UUID.
+func (ps *pseudonymizer) uid(uid string, now time.Time) string {
+	period := now.UnixNano() / int64(ps.rotation)
+	ps.mu.Lock()
+	if period != ps.period {
+		mac := hmac.New(sha256.New, ps.secret)
+		mac.Write(binary.BigEndian.AppendUint64([]byte("uid-salt"), uint64(period)))
+		ps.period, ps.key = period, mac.Sum(nil)
+	}
+	key := ps.key
+	ps.mu.Unlock()
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(uid))
+	var u uuid.UUID
+	copy(u[:], mac.Sum(nil))
+	u[6] = u[6]&0x0f | 0x80 // version 8, custom
+	u[8] = u[8]&0x3f | 0x80 // RFC 9562 variant
+	return u.String()
+}
+
+// maxPseudonymPeriods bounds the rotation periods Pseudonyms derives.
+const maxPseudonymPeriods = 100000
+
+// Pseudonyms returns the pseudonyms uid was stored under with
+// PseudonymizeUID, one per salt rotation period from from to to. It needs the
+// shared secret of SaltFile; a generated secret is lost on restart.
+func Pseudonyms(p *config.Privacy, uid string, from, to time.Time) ([]string, error) {
+	if p.SaltFile == "" {
+		return nil, errors.New("uids are pseudonymized with a generated secret, stored uids can't be derived without saltFile")
+	}
+	ps, err := newPseudonymizer(p)
+	if err != nil {
+		return nil, err
+	}
+	rotation := int64(ps.rotation)
+	first, last := from.UnixNano()/rotation, to.UnixNano()/rotation
+	if last-first >= maxPseudonymPeriods {
+		return nil, fmt.Errorf("too many salt rotation periods between %s and %s", from, to)
+	}
+	var uids []string
+	for period := first; period <= last; period++ {
+		uids = append(uids, ps.uid(uid, time.Unix(0, period*rotation)))
+	}
+	return uids, nil
+}
+
+// anonymizeIP truncates ip to the configured prefix length.
+func anonymizeIP(ip netip.Addr, p *config.Privacy) netip.Addr {
+	bits := p.IPv6Bits
+	if ip.Is4() {
+		bits = p.IPv4Bits
+	}
+	if bits == 0 || !ip.IsValid() {
+		return ip
+	}
+	prefix, _ := ip.Prefix(bits)
+	return prefix.Addr()
+}
+
+// anonymizeIPString truncates the textual IP address ip to the configured
+// prefix length. Anything else is returned as is.
+func anonymizeIPString(ip string, p *config.Privacy) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	return anonymizeIP(addr, p).String()
+}
+
+// logIP returns the client address of r as it may be logged.
+func logIP(r *http.Request, cfg *config.Config) string {
+	if ip := clientAddr(r); ip.IsValid() {
+		return anonymizeIP(ip, &cfg.Privacy).String()
+	}
+	return r.RemoteAddr
+}
+
+// logUID returns uid as it may be logged.
+func (h *HServ) logUID(uid string, cfg *config.Config) string {
+	if cfg.Privacy.PseudonymizeUID && uid != "" {
+		return h.pseudo.uid(uid, time.Now())
+	}
+	return uid
+}
+
+// trackingAllowed reports whether the persistent uid cookie may be set.
+func trackingAllowed(r *http.Request, p *config.Privacy) bool {
+	if p.CookieMode == config.CookieModeNone {
+		return false
+	}
+	if p.HonorDNT && (r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1") {
+		return false
+	}
+	if p.CookieMode == config.CookieModeAlways {
+		return true
+	}
+	if p.ConsentHeader != "" && isTrue(r.Header.Get(p.ConsentHeader)) {
+		return true
+	}
+	if p.ConsentCookie != "" {
+		if c, err := r.Cookie(p.ConsentCookie); err == nil && isTrue(c.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTrue(s string) bool {
+	switch strings.ToLower(s) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// privatize applies the privacy settings to ev before it is logged or sent
+// to the chunk writer.
+func (h *HServ) privatize(ev *chunklog.ChunkEvent, r *http.Request, cfg *config.Config) {
+	if ip := clientAddr(r); ip.IsValid() {
+		ev.IP = anonymizeIP(ip, &cfg.Privacy).String()
+	}
+	if cfg.Privacy.PseudonymizeUID && ev.UID != "" {
+		ev.UID = h.pseudo.uid(ev.UID, ev.Time)
+		merged := make([]string, len(ev.MergedUIDs))
+		for i, uid := range ev.MergedUIDs {
+			merged[i] = h.pseudo.uid(uid, ev.Time)
+		}
+		ev.MergedUIDs = merged
+	}
+}
@@ -0,0 +1,133 @@
+package hserv
+
+import (
+	"expvar"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/uamana/hserv/internal/chunklog"
+	"github.com/uamana/hserv/internal/config"
+)
+
+var rateLimited = expvar.NewMap("rate_limited")
+
+// rateSweepEvery is how often idle buckets are removed.
+const rateSweepEvery = time.Minute
+
+// tokenBucket is a set of token buckets keyed by client IP or uid. Rate and
+// burst are passed on every call, so they follow config reloads.
+type tokenBucket struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the bucket of key. If none is left, it returns
+// false and how long until the next token is available.
+func (tb *tokenBucket) allow(key string, rate float64, burst int, now time.Time) (bool, time.Duration) {
+	if burst < 1 {
+		burst = 1
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if tb.buckets == nil {
+		tb.buckets = make(map[string]*bucket)
+	}
+	if now.Sub(tb.lastSweep) > rateSweepEvery {
+		tb.sweep(rate, burst, now)
+	}
+
+	b := tb.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(burst), last: now}
+		tb.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely; they behave exactly like
+// new ones.
+func (tb *tokenBucket) sweep(rate float64, burst int, now time.Time) {
+	tb.lastSweep = now
+	full := time.Duration(float64(burst) / rate * float64(time.Second))
+	for key, b := range tb.buckets {
+		if now.Sub(b.last) > full {
+			delete(tb.buckets, key)
+		}
+	}
+}
+
+// rateLimit applies the playlist or chunk rate limit to r. A limited request
+// is answered with 429 and sent to chunklog flagged as rate limited, so it is
+// not counted as listening. It reports whether the request was limited.
+func (h *HServ) rateLimit(w http.ResponseWriter, r *http.Request, cfg *config.Config, path, authUID, country string, isPlaylist bool) bool {
+	limit, tb, kind := &cfg.RateLimit.Chunk, &h.chunkLimiter, "chunk"
+	if isPlaylist {
+		limit, tb, kind = &cfg.RateLimit.Playlist, &h.playlistLimiter, "playlist"
+	}
+	if limit.Rate <= 0 {
+		return false
+	}
+
+	ip := clientIP(r)
+	uid := resolveIdentity(r, cfg, authUID, true).uid
+	key := ip
+	if limit.Key == config.RateKeyUID && uid != "" {
+		key = "uid:" + uid
+	}
+	ok, retry := tb.allow(key, limit.Rate, limit.Burst, time.Now())
+	if ok {
+		return false
+	}
+
+	ev := chunklog.ChunkEvent{
+		Time:          time.Now(),
+		Path:          path,
+		IP:            r.RemoteAddr,
+		UserAgent:     r.UserAgent(),
+		Referer:       r.Referer(),
+		SID:           r.URL.Query().Get(cfg.SidName),
+		UID:           uid,
+		ClientSubject: clientSubject(r),
+		Country:       country,
+		RateLimited:   true,
+	}
+	if cfg.SIDSigning.Enabled && ev.SID != "" {
+		// Log the session uuid, not the signed token.
+		ev.SID, ev.SIDStatus = h.sids.verify(ev.SID, cfg.SIDSigning.MaxAge.Duration, ev.Time)
+	}
+	h.privatize(&ev, r, cfg)
+	rateLimited.Add(kind, 1)
+	slog.Info("rate limited",
+		"kind", kind,
+		"path", path,
+		"ip", ev.IP,
+		"sid", ev.SID,
+		"uid", ev.UID,
+		"retryAfter", retry,
+	)
+	if h.ChunkWriter != nil {
+		h.ChunkWriter.Send(ev)
+	}
+
+	setHeaders(w)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	return true
+}
@@ -0,0 +1,86 @@
+package hserv
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+var refererBlocked = expvar.NewMap("referer_blocked")
+
+// maxRefererKeys bounds the referer hosts counted separately by the
+// referer_blocked metric; the header is client controlled. Further hosts are
+// counted as "other".
+const maxRefererKeys = 100
+
+var (
+	refererKeysMu sync.Mutex
+	refererKeys   = make(map[string]bool)
+)
+
+// refererKey returns the referer_blocked metric key of host.
+func refererKey(host string) string {
+	if host == "" {
+		return "(none)"
+	}
+	refererKeysMu.Lock()
+	defer refererKeysMu.Unlock()
+	if !refererKeys[host] {
+		if len(refererKeys) >= maxRefererKeys {
+			return "other"
+		}
+		refererKeys[host] = true
+	}
+	return host
+}
+
+// refererHost returns the host of the Origin header, or of the Referer header
+// if there is no Origin. It is empty if neither is sent.
+func refererHost(r *http.Request) string {
+	ref := r.Header.Get("Origin")
+	if ref == "" || ref == "null" {
+		ref = r.Referer()
+	}
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Hostname() == "" {
+		return ref
+	}
+	return u.Hostname()
+}
+
+// checkReferer applies the referer rule of the request path. A disallowed
+// request gets the fallback playlist or 403. It reports whether the request
+// may be served.
+func (h *HServ) checkReferer(w http.ResponseWriter, r *http.Request, cfg *config.Config, isPlaylist bool) bool {
+	rule := cfg.Referer.Rule(r.URL.Path)
+	host := refererHost(r)
+	if rule.Allows(host) {
+		return true
+	}
+
+	refererBlocked.Add(refererKey(host), 1)
+	slog.Info("referer blocked", "referer", host, "ip", logIP(r, cfg), "path", r.URL.Path)
+
+	if isPlaylist && rule.Fallback != "" {
+		data, err := os.ReadFile(rule.Fallback)
+		if err == nil {
+			setHeaders(w)
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			if r.Method != http.MethodHead {
+				w.Write(data)
+			}
+			return false
+		}
+		slog.Error("failed to read referer fallback playlist", "error", err)
+	}
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	return false
+}
@@ -0,0 +1,187 @@
+package hserv
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+var restreamAlerts = expvar.NewMap("restream_alerts")
+
+// restreamBuffer is the media a player may fetch ahead of real time when it
+// starts playing.
+const restreamBuffer = time.Minute
+
+// maxRestreamAlerts is the number of recent alerts kept for the admin
+// endpoint.
+const maxRestreamAlerts = 100
+
+// restreamDetector flags sids and uids that look relayed to many listeners
+// or shared, see config.Restream.
+type restreamDetector struct {
+	mu        sync.Mutex
+	tracks    map[string]*restreamTrack // "sid:" or "uid:" + id
+	blocked   map[string]time.Time      // key -> end of block
+	alerts    []restreamAlert
+	lastSweep time.Time
+}
+
+type restreamTrack struct {
+	lastSeen  time.Time
+	ips       map[string]time.Time
+	asns      map[uint32]time.Time
+	started   time.Time
+	media     time.Duration
+	country   string
+	countryAt time.Time
+	// flagged is the reason the track was flagged for.
+	flagged string
+}
+
+type restreamAlert struct {
+	Time   time.Time `json:"time"`
+	Key    string    `json:"key"`
+	Reason string    `json:"reason"`
+	IP     string    `json:"ip"`
+}
+
+// restreamASNDB returns the ASN database path needed by the restream
+// detector, or an empty string.
+func restreamASNDB(cfg *config.Config) string {
+	if cfg.Restream.MaxASNs > 0 {
+		return cfg.ASNDB
+	}
+	return ""
+}
+
+// observe records a served chunk of sid and uid. It returns the reason the
+// sid or uid was flagged for, or an empty string. Detection uses the raw ip
+// and uid; alerts only show them as logIP and logUID, with the privacy
+// settings applied.
+func (d *restreamDetector) observe(cfg *config.Restream, now time.Time, sid, uid, ip string, asn uint32, country string, chunk time.Duration, logUID, logIP string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.init(cfg, now)
+
+	reason := d.observeKey(cfg, now, "sid:"+sid, "sid:"+sid, ip, logIP, asn, country, chunk)
+	if r := d.observeKey(cfg, now, "uid:"+uid, "uid:"+logUID, ip, logIP, asn, country, 0); reason == "" {
+		reason = r
+	}
+	return reason
+}
+
+func (d *restreamDetector) observeKey(cfg *config.Restream, now time.Time, key, logKey, ip, logIP string, asn uint32, country string, chunk time.Duration) string {
+	window := cfg.Window.Duration
+	t := d.tracks[key]
+	if t == nil || now.Sub(t.lastSeen) >= window {
+		t = &restreamTrack{
+			ips:     make(map[string]time.Time),
+			asns:    make(map[uint32]time.Time),
+			started: now,
+		}
+		d.tracks[key] = t
+	}
+	t.lastSeen = now
+	for k, seen := range t.ips {
+		if now.Sub(seen) >= window {
+			delete(t.ips, k)
+		}
+	}
+	for k, seen := range t.asns {
+		if now.Sub(seen) >= window {
+			delete(t.asns, k)
+		}
+	}
+	t.ips[ip] = now
+	if asn != 0 {
+		t.asns[asn] = now
+	}
+	t.media += chunk
+
+	reason := ""
+	switch {
+	case cfg.MaxIPs > 0 && len(t.ips) > cfg.MaxIPs:
+		reason = "ips"
+	case cfg.MaxASNs > 0 && len(t.asns) > cfg.MaxASNs:
+		reason = "asns"
+	case cfg.MaxSpeed > 0 && chunk > 0 &&
+		float64(t.media-restreamBuffer) > cfg.MaxSpeed*float64(now.Sub(t.started)):
+		reason = "speed"
+	case cfg.GeoWindow.Duration > 0 && country != "" && t.country != "" &&
+		country != t.country && now.Sub(t.countryAt) < cfg.GeoWindow.Duration:
+		reason = "geo"
+	}
+	if country != "" {
+		t.country, t.countryAt = country, now
+	}
+
+	if reason != "" && t.flagged == "" {
+		t.flagged = reason
+		restreamAlerts.Add(reason, 1)
+		slog.Warn("possible restream or credential sharing", "key", logKey, "reason", reason, "ip", logIP)
+		d.alerts = append(d.alerts, restreamAlert{Time: now, Key: logKey, Reason: reason, IP: logIP})
+		if len(d.alerts) > maxRestreamAlerts {
+			d.alerts = d.alerts[1:]
+		}
+		if cfg.BlockFor.Duration > 0 {
+			d.blocked[key] = now.Add(cfg.BlockFor.Duration)
+		}
+	}
+	return t.flagged
+}
+
+// isBlocked reports whether sid or uid is blocked.
+func (d *restreamDetector) isBlocked(sid, uid string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, key := range []string{"sid:" + sid, "uid:" + uid} {
+		if until, ok := d.blocked[key]; ok && now.Before(until) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *restreamDetector) init(cfg *config.Restream, now time.Time) {
+	if d.tracks == nil {
+		d.tracks = make(map[string]*restreamTrack)
+		d.blocked = make(map[string]time.Time)
+	}
+	if now.Sub(d.lastSweep) < sessionSweepEvery {
+		return
+	}
+	d.lastSweep = now
+	for key, t := range d.tracks {
+		if now.Sub(t.lastSeen) >= cfg.Window.Duration {
+			delete(d.tracks, key)
+		}
+	}
+	for key, until := range d.blocked {
+		if !now.Before(until) {
+			delete(d.blocked, key)
+		}
+	}
+}
+
+// recentAlerts returns a copy of the recent alerts, oldest first.
+func (d *restreamDetector) recentAlerts() []restreamAlert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]restreamAlert{}, d.alerts...)
+}
+
+// checkRestream answers 403 if the sid or uid is blocked by the restream
+// detector and reports whether the request may be served.
+func (h *HServ) checkRestream(w http.ResponseWriter, r *http.Request, cfg *config.Config, sid, uid string) bool {
+	if cfg.Restream.BlockFor.Duration <= 0 || !h.restream.isBlocked(sid, uid, time.Now()) {
+		return true
+	}
+	accessDenied.Add("restream", 1)
+	slog.Info("access denied", "reason", "restream", "ip", logIP(r, cfg), "path", r.URL.Path)
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	return false
+}
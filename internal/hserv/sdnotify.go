@@ -0,0 +1,28 @@
+package hserv
+
+import (
+	"log/slog"
+	"net"
+	"os"
+)
+
+// sdNotify sends a state update to systemd if hserv runs as a Type=notify
+// service. It is a no-op otherwise.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Error("failed to connect to systemd notify socket", "error", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Error("failed to notify systemd", "state", state, "error", err)
+	}
+}
@@ -0,0 +1,196 @@
+package hserv
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+var streamLimited = expvar.NewMap("stream_limit")
+
+// sessionSweepEvery is how often expired sessions are removed.
+const sessionSweepEvery = time.Minute
+
+// sessionTracker keeps the sessions of every uid. A session stays alive while
+// its chunks keep arriving within the session TTL.
+type sessionTracker struct {
+	mu        sync.Mutex
+	byUID     map[string]map[string]*session
+	evicted   map[string]time.Time // sid -> eviction time
+	lastSweep time.Time
+}
+
+type session struct {
+	started  time.Time
+	lastSeen time.Time
+	client   string // IP and user agent of the client that started it
+	token    string // sid token handed out for it, with its issue time
+}
+
+// start registers sid as a new session of uid unless it would exceed max
+// alive sessions. In evict mode the oldest session is evicted instead. An
+// evicted session can't start again. It reports whether the session may start
+// and the evicted sid, if any. client and token are kept for resume.
+func (t *sessionTracker) start(uid, sid, client, token string, max int, evict bool, ttl time.Duration, now time.Time) (bool, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.init(ttl, now)
+
+	if _, ok := t.evicted[sid]; ok {
+		return false, ""
+	}
+	sessions := t.byUID[uid]
+	if s := sessions[sid]; s != nil && now.Sub(s.lastSeen) < ttl {
+		s.lastSeen = now
+		return true, ""
+	}
+	delete(sessions, sid)
+
+	var (
+		alive     int
+		oldestSID string
+		oldest    *session
+	)
+	for id, s := range sessions {
+		if now.Sub(s.lastSeen) >= ttl {
+			delete(sessions, id)
+			continue
+		}
+		alive++
+		if oldest == nil || s.started.Before(oldest.started) {
+			oldestSID, oldest = id, s
+		}
+	}
+	evictedSID := ""
+	if max > 0 && alive >= max {
+		if !evict {
+			return false, ""
+		}
+		delete(sessions, oldestSID)
+		t.evicted[oldestSID] = now
+		evictedSID = oldestSID
+	}
+	if sessions == nil {
+		sessions = make(map[string]*session)
+		t.byUID[uid] = sessions
+	}
+	sessions[sid] = &session{started: now, lastSeen: now, client: client, token: token}
+	return true, evictedSID
+}
+
+// resume returns the most recently seen alive session of uid started by
+// client and its sid token, or empty strings.
+func (t *sessionTracker) resume(uid, client string, ttl time.Duration, now time.Time) (sid, token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.init(ttl, now)
+
+	var last time.Time
+	for id, s := range t.byUID[uid] {
+		if s.client == client && now.Sub(s.lastSeen) < ttl && s.lastSeen.After(last) {
+			sid, token, last = id, s.token, s.lastSeen
+		}
+	}
+	return sid, token
+}
+
+func (t *sessionTracker) init(ttl time.Duration, now time.Time) {
+	if t.byUID == nil {
+		t.byUID = make(map[string]map[string]*session)
+		t.evicted = make(map[string]time.Time)
+	}
+	if now.Sub(t.lastSweep) < sessionSweepEvery {
+		return
+	}
+	t.lastSweep = now
+	for uid, sessions := range t.byUID {
+		for sid, s := range sessions {
+			if now.Sub(s.lastSeen) >= ttl {
+				delete(sessions, sid)
+			}
+		}
+		if len(sessions) == 0 {
+			delete(t.byUID, uid)
+		}
+	}
+	// An evicted player gives up long before this, so the sid can be forgotten.
+	for sid, at := range t.evicted {
+		if now.Sub(at) >= 10*ttl {
+			delete(t.evicted, sid)
+		}
+	}
+}
+
+// maxStreams returns the session limit for the request: a limit set for the
+// verified client certificate, then for the partner token, then the default.
+func maxStreams(r *http.Request, cfg *config.StreamLimit) int {
+	if max, ok := cfg.ClientCerts[clientSubject(r)]; ok {
+		return max
+	}
+	if token := r.URL.Query().Get(cfg.PartnerParam); token != "" {
+		if max, ok := cfg.Partners[token]; ok {
+			return max
+		}
+	}
+	return cfg.MaxPerUID
+}
+
+// sessionClient identifies the client of a request for resuming sessions.
+func sessionClient(r *http.Request) string {
+	return clientIP(r) + " " + r.UserAgent()
+}
+
+// reuseSession returns the sid and sid token for a request without a sid:
+// the alive session the same client (IP and user agent) started for the uid,
+// so that refreshing a media playlist doesn't start a new session each time,
+// or else the ones given. Another client of the uid gets its own session. The
+// session's token is handed out again as it was, so a signed sid still
+// expires MaxAge after it was first issued.
+func (h *HServ) reuseSession(r *http.Request, cfg *config.Config, uid, sid, sidToken string) (string, string) {
+	if !cfg.StreamLimit.Enabled() || r.URL.Query().Get(cfg.SidName) != "" {
+		return sid, sidToken
+	}
+	now := time.Now()
+	live, token := h.sessions.resume(uid, sessionClient(r), cfg.StreamLimit.SessionTTL.Duration, now)
+	if live == "" {
+		return sid, sidToken
+	}
+	if cfg.SIDSigning.Enabled {
+		if _, status := h.sids.verify(token, cfg.SIDSigning.MaxAge.Duration, now); status != sidValid {
+			return sid, sidToken
+		}
+	}
+	return live, token
+}
+
+// limitStreams starts or refreshes the session of a playlist or chunk
+// request and answers 403 if the uid has too many sessions or the session was
+// evicted. Chunk requests of an unknown session start it, so clients that
+// skip the playlist are limited too. It reports whether the request may be
+// served.
+func (h *HServ) limitStreams(w http.ResponseWriter, r *http.Request, cfg *config.Config, uid, sid, sidToken string, isPlaylist bool) bool {
+	limit := &cfg.StreamLimit
+	if !limit.Enabled() {
+		return true
+	}
+	now := time.Now()
+	ttl := limit.SessionTTL.Duration
+
+	ok, evicted := h.sessions.start(uid, sid, sessionClient(r), sidToken, maxStreams(r, limit),
+		limit.Mode == config.StreamModeEvict, ttl, now)
+	if evicted != "" {
+		streamLimited.Add("evicted", 1)
+		slog.Info("too many sessions, evicted oldest", "uid", h.logUID(uid, cfg), "sid", sid, "evicted", evicted)
+	}
+	if !ok {
+		streamLimited.Add("rejected", 1)
+		slog.Info("too many sessions or evicted, rejected", "uid", h.logUID(uid, cfg), "sid", sid, "ip", logIP(r, cfg), "playlist", isPlaylist)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package hserv
+
+import (
+	"net/http"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+var testStream = fstest.MapFS{
+	"s.m3u8":                        {Data: []byte("#EXTM3U\n#EXTINF:10.0,\nmp3_hifi_1700000000_10.0_1.ts\n")},
+	"mp3_hifi_1700000000_10.0_1.ts": {Data: []byte("chunk")},
+}
+
+func TestStreamLimitTwoClientsWithoutSID(t *testing.T) {
+	phone := testClient{addr: "192.0.2.1:40000", userAgent: "phone"}
+	laptop := testClient{addr: "198.51.100.7:50000", userAgent: "laptop"}
+
+	for _, mode := range []string{config.StreamModeReject, config.StreamModeEvict} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.StreamLimit.MaxPerUID = 1
+			cfg.StreamLimit.Mode = mode
+			h := newTestHServ(t, cfg, testStream)
+
+			w := phone.get(h, "/s.m3u8?uid=shared")
+			if w.Code != http.StatusOK {
+				t.Fatalf("phone playlist: %d", w.Code)
+			}
+			phoneSID := playlistQuery(t, w.Body.String()).Get("sid")
+
+			// Refreshing the playlist without a sid stays in the session.
+			w = phone.get(h, "/s.m3u8?uid=shared")
+			if w.Code != http.StatusOK {
+				t.Fatalf("phone refresh: %d", w.Code)
+			}
+			if sid := playlistQuery(t, w.Body.String()).Get("sid"); sid != phoneSID {
+				t.Fatalf("phone refresh got sid %q, want %q", sid, phoneSID)
+			}
+
+			// Another client of the uid without a sid is a second session.
+			w = laptop.get(h, "/s.m3u8?uid=shared")
+			if mode == config.StreamModeReject {
+				if w.Code != http.StatusForbidden {
+					t.Fatalf("laptop playlist: %d, want 403", w.Code)
+				}
+				return
+			}
+			if w.Code != http.StatusOK {
+				t.Fatalf("laptop playlist: %d", w.Code)
+			}
+			if sid := playlistQuery(t, w.Body.String()).Get("sid"); sid == phoneSID {
+				t.Fatal("laptop joined the phone's session")
+			}
+			w = phone.get(h, "/mp3_hifi_1700000000_10.0_1.ts?uid=shared&sid="+phoneSID)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("evicted phone chunk: %d, want 403", w.Code)
+			}
+		})
+	}
+}
+
+func TestReuseSessionKeepsSIDIssueTime(t *testing.T) {
+	cfg := config.Default()
+	cfg.StreamLimit.MaxPerUID = 2
+	cfg.SIDSigning.Enabled = true
+	h := newTestHServ(t, cfg, testStream)
+	phone := testClient{addr: "192.0.2.1:40000", userAgent: "phone"}
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name   string
+		issued time.Time
+		reuse  bool
+	}{
+		{"valid", now.Add(-time.Hour), true},
+		{"expired", now.Add(-25 * time.Hour), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			uid := "uid-" + tc.name
+			token := h.sids.sign(tc.name, tc.issued)
+			h.sessions.start(uid, tc.name, sessionClient(phone.request("/s.m3u8")), token,
+				cfg.StreamLimit.MaxPerUID, false, cfg.StreamLimit.SessionTTL.Duration, now)
+
+			w := phone.get(h, "/s.m3u8?uid="+uid)
+			if w.Code != http.StatusOK {
+				t.Fatalf("playlist: %d", w.Code)
+			}
+			got := playlistQuery(t, w.Body.String()).Get("sid")
+			if (got == token) != tc.reuse {
+				t.Errorf("playlist sid %q, session token %q, want reuse %v", got, token, tc.reuse)
+			}
+		})
+	}
+}
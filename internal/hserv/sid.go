@@ -0,0 +1,130 @@
+package hserv
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"expvar"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uamana/hserv/internal/chunklog"
+	"github.com/uamana/hserv/internal/config"
+)
+
+// Status of a signed sid, stored with chunk events.
+const (
+	sidValid   = "valid"
+	sidInvalid = "invalid"
+	sidExpired = "expired"
+)
+
+var sidRejected = expvar.NewMap("sid_rejected")
+
+// sidSigner issues and verifies signed sids of the form
+// "<uuid>.<issue unix time>.<mac>".
+type sidSigner struct {
+	key []byte
+}
+
+func newSIDSigner(s *config.SIDSigning) (*sidSigner, error) {
+	if s.KeyFile == "" {
+		key := make([]byte, 32)
+		rand.Read(key)
+		return &sidSigner{key: key}, nil
+	}
+	key, err := readSecret(s.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &sidSigner{key: key}, nil
+}
+
+func (s *sidSigner) mac(payload string) string {
+	m := hmac.New(sha256.New, s.key)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil)[:16])
+}
+
+// issue returns a new signed sid and its uuid.
+func (s *sidSigner) issue(now time.Time) (token, id string) {
+	id = uuid.New().String()
+	return s.sign(id, now), id
+}
+
+// sign returns the signed sid of id, issued now.
+func (s *sidSigner) sign(id string, now time.Time) string {
+	payload := id + "." + strconv.FormatInt(now.Unix(), 10)
+	return payload + "." + s.mac(payload)
+}
+
+// verify returns the uuid of a signed sid and its status.
+func (s *sidSigner) verify(token string, maxAge time.Duration, now time.Time) (id, status string) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", sidInvalid
+	}
+	payload, mac := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(s.mac(payload))) {
+		return "", sidInvalid
+	}
+	id, issued, ok := strings.Cut(payload, ".")
+	sec, err := strconv.ParseInt(issued, 10, 64)
+	if !ok || err != nil {
+		return "", sidInvalid
+	}
+	if now.Sub(time.Unix(sec, 0)) > maxAge {
+		return id, sidExpired
+	}
+	return id, sidValid
+}
+
+// resolveSID returns the signed sid of the request, its uuid and status.
+// Playlist requests without a valid sid get a new one; a bad sid of a chunk
+// request is returned with its status, to be rejected.
+func (h *HServ) resolveSID(r *http.Request, cfg *config.Config, isPlaylist bool) (token, id, status string) {
+	now := time.Now()
+	token = r.URL.Query().Get(cfg.SidName)
+	if token == "" && isPlaylist {
+		token, id = h.sids.issue(now)
+		return token, id, sidValid
+	}
+	id, status = h.sids.verify(token, cfg.SIDSigning.MaxAge.Duration, now)
+	if status == sidValid {
+		return token, id, status
+	}
+	sidRejected.Add(status, 1)
+	if isPlaylist {
+		token, id = h.sids.issue(now)
+		return token, id, sidValid
+	}
+	return token, id, status
+}
+
+// rejectSID answers 403 to a chunk request with an invalid or expired sid
+// and records it as a chunk event that was not served.
+func (h *HServ) rejectSID(w http.ResponseWriter, r *http.Request, cfg *config.Config, path, uid, sid, status, country string) {
+	ev := chunklog.ChunkEvent{
+		Time:          time.Now(),
+		Path:          path,
+		IP:            r.RemoteAddr,
+		UserAgent:     r.UserAgent(),
+		Referer:       r.Referer(),
+		SID:           sid,
+		UID:           uid,
+		ClientSubject: clientSubject(r),
+		Country:       country,
+		SIDStatus:     status,
+	}
+	h.privatize(&ev, r, cfg)
+	slog.Info("sid rejected", "status", status, "path", path, "ip", ev.IP, "uid", ev.UID)
+	if h.ChunkWriter != nil {
+		h.ChunkWriter.Send(ev)
+	}
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
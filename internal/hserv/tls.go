@@ -0,0 +1,119 @@
+package hserv
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/uamana/hserv/internal/config"
+)
+
+// tlsConfig returns the TLS config of the listener. The TLS policy is looked
+// up on every handshake, so it follows config reloads.
+func (h *HServ) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: h.getCertificate,
+		NextProtos:     h.nextProtos(),
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return h.tlsPolicy.Load(), nil
+		},
+	}
+}
+
+func (h *HServ) nextProtos() []string {
+	protos := []string{"h2", "http/1.1"}
+	if h.acme != nil {
+		protos = append(protos, acme.ALPNProto)
+	}
+	return protos
+}
+
+// buildTLSPolicy builds the per-handshake TLS config from cfg, reading the
+// client CA bundle and session ticket keys from disk.
+func (h *HServ) buildTLSPolicy(cfg *config.Config) (*tls.Config, error) {
+	// The policy was validated when the config was loaded.
+	minVersion, _ := cfg.TLS.Version()
+	cipherSuites, _ := cfg.TLS.CipherSuiteIDs()
+	curves, _ := cfg.TLS.CurveIDs()
+	clientAuth, _ := cfg.TLS.ClientAuthType()
+
+	tc := &tls.Config{
+		GetCertificate:   h.getCertificate,
+		NextProtos:       h.nextProtos(),
+		MinVersion:       minVersion,
+		CipherSuites:     cipherSuites,
+		CurvePreferences: curves,
+		ClientAuth:       clientAuth,
+	}
+	if cfg.TLS.ClientCA != "" {
+		pem, err := os.ReadFile(cfg.TLS.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+		}
+		tc.ClientCAs = x509.NewCertPool()
+		if !tc.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in client CA bundle")
+		}
+	}
+	if cfg.TLS.SessionTicketKeys != "" {
+		keys, err := readTicketKeys(cfg.TLS.SessionTicketKeys)
+		if err != nil {
+			return nil, err
+		}
+		tc.SetSessionTicketKeys(keys)
+	}
+	return tc, nil
+}
+
+func readTicketKeys(path string) ([][32]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session ticket keys: %w", err)
+	}
+	defer f.Close()
+
+	var keys [][32]byte
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(text)
+		if err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("%s:%d: session ticket key must be 32 bytes, base64 encoded", path, line)
+		}
+		keys = append(keys, [32]byte(raw))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read session ticket keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no session ticket keys", path)
+	}
+	return keys, nil
+}
+
+func (h *HServ) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if h.acme != nil && (h.kpr == nil || h.acme.handles(hello)) {
+		return h.acme.m.GetCertificate(hello)
+	}
+	return h.kpr.lookup(hello), nil
+}
+
+// clientSubject returns the subject of the verified client certificate, or
+// an empty string.
+func clientSubject(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.String()
+}
@@ -0,0 +1,18 @@
+-- Subject of the verified TLS client certificate (partner rebroadcasters).
+
+-- 001_init.sql creates the table as requests; hserv writes chunk_requests.
+-- Databases where the table was already renamed by hand are left alone.
+DO $$
+BEGIN
+    IF to_regclass('requests') IS NOT NULL AND to_regclass('chunk_requests') IS NULL THEN
+        ALTER TABLE requests RENAME TO chunk_requests;
+    END IF;
+END $$;
+
+ALTER TABLE chunk_requests ADD COLUMN IF NOT EXISTS client_subject TEXT;
+
+---- create above / drop below ----
+
+ALTER TABLE chunk_requests DROP COLUMN IF EXISTS client_subject;
+
+ALTER TABLE chunk_requests RENAME TO requests;
@@ -0,0 +1,7 @@
+-- Requests rejected by a rate limit are logged but were not served.
+
+ALTER TABLE chunk_requests ADD COLUMN IF NOT EXISTS rate_limited BOOLEAN NOT NULL DEFAULT FALSE;
+
+---- create above / drop below ----
+
+ALTER TABLE chunk_requests DROP COLUMN IF EXISTS rate_limited;
@@ -0,0 +1,7 @@
+-- ISO country code of the client, resolved from the country database.
+
+ALTER TABLE chunk_requests ADD COLUMN IF NOT EXISTS country TEXT NOT NULL DEFAULT '';
+
+---- create above / drop below ----
+
+ALTER TABLE chunk_requests DROP COLUMN IF EXISTS country;
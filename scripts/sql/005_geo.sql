@@ -0,0 +1,13 @@
+-- Location and network of the client, resolved from the geo and ASN databases.
+
+ALTER TABLE chunk_requests ADD COLUMN IF NOT EXISTS region TEXT NOT NULL DEFAULT '';
+ALTER TABLE chunk_requests ADD COLUMN IF NOT EXISTS city TEXT NOT NULL DEFAULT '';
+ALTER TABLE chunk_requests ADD COLUMN IF NOT EXISTS asn BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE chunk_requests ADD COLUMN IF NOT EXISTS isp TEXT NOT NULL DEFAULT '';
+
+---- create above / drop below ----
+
+ALTER TABLE chunk_requests DROP COLUMN IF EXISTS isp;
+ALTER TABLE chunk_requests DROP COLUMN IF EXISTS asn;
+ALTER TABLE chunk_requests DROP COLUMN IF EXISTS city;
+ALTER TABLE chunk_requests DROP COLUMN IF EXISTS region;
@@ -0,0 +1,14 @@
+-- Audit log of data subject requests executed with "hserv privacy".
+
+CREATE TABLE IF NOT EXISTS privacy_audit (
+    time     TIMESTAMPTZ NOT NULL,
+    action   TEXT        NOT NULL,
+    uid      UUID        NOT NULL,
+    rows     BIGINT      NOT NULL,
+    operator TEXT        NOT NULL DEFAULT '',
+    reason   TEXT        NOT NULL DEFAULT ''
+);
+
+---- create above / drop below ----
+
+DROP TABLE IF EXISTS privacy_audit;
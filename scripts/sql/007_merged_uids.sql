@@ -0,0 +1,8 @@
+-- Other uids a request carried when its identity sources (auth, cookie,
+-- query) disagreed.
+
+ALTER TABLE chunk_requests ADD COLUMN IF NOT EXISTS merged_uids TEXT[];
+
+---- create above / drop below ----
+
+ALTER TABLE chunk_requests DROP COLUMN IF EXISTS merged_uids;
@@ -0,0 +1,8 @@
+-- Status of signed sids: valid, invalid or expired (empty if sids are not
+-- signed). Requests with invalid or expired sids are logged but not served.
+
+ALTER TABLE chunk_requests ADD COLUMN IF NOT EXISTS sid_status TEXT NOT NULL DEFAULT '';
+
+---- create above / drop below ----
+
+ALTER TABLE chunk_requests DROP COLUMN IF EXISTS sid_status;
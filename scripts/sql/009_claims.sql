@@ -0,0 +1,7 @@
+-- Selected token claims (e.g. plan, partner) of authenticated listeners.
+
+ALTER TABLE chunk_requests ADD COLUMN IF NOT EXISTS claims JSONB;
+
+---- create above / drop below ----
+
+ALTER TABLE chunk_requests DROP COLUMN IF EXISTS claims;
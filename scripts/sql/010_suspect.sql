@@ -0,0 +1,8 @@
+-- Reason the restream detector flagged the sid or uid for: ips, asns, speed
+-- or geo.
+
+ALTER TABLE chunk_requests ADD COLUMN IF NOT EXISTS suspect TEXT;
+
+---- create above / drop below ----
+
+ALTER TABLE chunk_requests DROP COLUMN IF EXISTS suspect;